@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type workersResponse struct {
+	Workers int `json:"workers"`
+}
+
+// isLoopbackHost reports whether host only accepts local connections. An
+// empty host is served on localhost by serveAdmin.
+func isLoopbackHost(host string) bool {
+	if host == "" || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireToken rejects requests that do not carry token as a bearer token.
+func requireToken(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminHandler returns an HTTP handler for adjusting the number of active
+// upload workers, up to maxWorkers. If token is set, every request must
+// carry it as a bearer token.
+func adminHandler(log *zap.Logger, pool *workerPool, token string, maxWorkers int) http.Handler {
+	writeWorkers := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(workersResponse{Workers: pool.Size()})
+	}
+
+	// resize adjusts the pool by delta workers, defaulting to the "n" query
+	// parameter or 1.
+	resize := func(sign int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			n := 1
+			if s := r.URL.Query().Get("n"); s != "" {
+				var err error
+				n, err = strconv.Atoi(s)
+				if err != nil || n < 1 || n > maxWorkers {
+					http.Error(w, "n must be between 1 and "+strconv.Itoa(maxWorkers), http.StatusBadRequest)
+					return
+				}
+			}
+
+			target := pool.Size() + sign*n
+			if target < 1 {
+				http.Error(w, "at least one worker must remain active", http.StatusBadRequest)
+				return
+			} else if sign > 0 && target > maxWorkers {
+				http.Error(w, "at most "+strconv.Itoa(maxWorkers)+" workers can be active", http.StatusBadRequest)
+				return
+			}
+			pool.Resize(target)
+			log.Info("adjusted workers", zap.Int("workers", target))
+			writeWorkers(w)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /workers", func(w http.ResponseWriter, r *http.Request) {
+		writeWorkers(w)
+	})
+	mux.HandleFunc("POST /workers/increase", resize(1))
+	mux.HandleFunc("POST /workers/decrease", resize(-1))
+	if token != "" {
+		return requireToken(token, mux)
+	}
+	return mux
+}
+
+// serveAdmin serves the admin API on addr until the context is canceled. If
+// addr has no host, the API only listens on localhost.
+func serveAdmin(ctx context.Context, log *zap.Logger, addr string, pool *workerPool) error {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort("localhost", port)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:     adminHandler(log, pool, adminToken, adminMaxWorkers),
+		ReadTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("admin API listening", zap.Stringer("addr", l.Addr()))
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("admin API failed", zap.Error(err))
+		}
+	}()
+	return nil
+}
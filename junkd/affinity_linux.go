@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// affinitySupported is whether -affinity can pin threads on this platform.
+const affinitySupported = true
+
+// pinThread restricts the calling OS thread to cpus and returns a function
+// that restores its previous affinity. The caller must have locked the
+// goroutine to its thread.
+func pinThread(cpus []int) (func() error, error) {
+	var prev unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &prev); err != nil {
+		return nil, fmt.Errorf("failed to get CPU affinity: %w", err)
+	}
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return nil, fmt.Errorf("failed to set CPU affinity: %w", err)
+	}
+	return func() error {
+		if err := unix.SchedSetaffinity(0, &prev); err != nil {
+			return fmt.Errorf("failed to restore CPU affinity: %w", err)
+		}
+		return nil
+	}, nil
+}
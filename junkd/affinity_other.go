@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// affinitySupported is whether -affinity can pin threads on this platform.
+const affinitySupported = false
+
+func pinThread([]int) (func() error, error) {
+	return nil, errors.New("CPU affinity is only supported on Linux")
+}
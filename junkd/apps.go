@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/indexd/api/app"
+	"go.sia.tech/indexd/sdk"
+	"go.uber.org/zap"
+)
+
+// An appClient is the SDK client for a single app identity.
+type appClient struct {
+	index int
+	sk    types.PrivateKey
+	// client is replaced when the app is approved again after the session
+	// check found its approval revoked
+	client atomic.Pointer[sdk.SDK]
+	// healthy is false while the app's approval is revoked
+	healthy atomic.Bool
+	// reconnecting is set while waiting for the user to approve the app
+	// again
+	reconnecting atomic.Bool
+
+	uploads  atomic.Int64
+	failures atomic.Int64
+	// redundantBytes is the number of redundant bytes uploaded
+	redundantBytes atomic.Int64
+}
+
+// registerRequest identifies junkd to the indexer when connecting an app.
+var registerRequest = app.RegisterAppRequest{
+	Name:        "junkd Uploader",
+	Description: "A tool to upload junk data to the indexer",
+	LogoURL:     "https://example.com/logo.png",
+	ServiceURL:  "https://example.com/service",
+}
+
+// connectApp registers the app key with the indexer, waiting for the user to
+// approve the connection if necessary, and returns an SDK client for it.
+func connectApp(ctx context.Context, log *zap.Logger, index int, sk types.PrivateKey) *appClient {
+	log = log.With(zap.Int("app", index))
+
+	resp, connected, err := sdk.Connect(ctx, indexerURL, sk, registerRequest)
+	for attempt := 1; err != nil && retryStartup(ctx, log, "connect", attempt, err); attempt++ {
+		resp, connected, err = sdk.Connect(ctx, indexerURL, sk, registerRequest)
+	}
+	if err != nil {
+		fatal(log, codeConnectFailed, "failed to connect app", err)
+	} else if !connected {
+		log.Info("please approve app connection", zap.String("url", resp.ResponseURL))
+		if openApprovalBrowser {
+			if err := openBrowser(resp.ResponseURL); err != nil && !errors.Is(err, errHeadless) {
+				log.Warn("failed to open browser", zap.Error(err))
+			}
+		}
+		if connected, err := resp.WaitForApproval(ctx); err != nil {
+			fatal(log, codeApprovalFailed, "failed to wait for app approval", err)
+		} else if !connected {
+			fatal(log, codeApprovalDenied, "user denied app connection", nil)
+		}
+	}
+	log.Info("junkd connected")
+
+	client, err := sdk.NewSDK(indexerURL, sk, sdk.WithLogger(log.Named("sdk")))
+	for attempt := 1; err != nil && retryStartup(ctx, log, "create SDK client", attempt, err); attempt++ {
+		client, err = sdk.NewSDK(indexerURL, sk, sdk.WithLogger(log.Named("sdk")))
+	}
+	if err != nil {
+		fatal(log, codeSDKFailed, "failed to create SDK client", err)
+	}
+	a := &appClient{index: index, sk: sk}
+	a.client.Store(client)
+	a.healthy.Store(true)
+	return a
+}
+
+const (
+	// sessionCheckRetries is the number of times a session check that
+	// failed with a transient error is retried before waiting for the next
+	// interval.
+	sessionCheckRetries = 3
+	// sessionCheckBackoff is the delay before the first session check retry,
+	// doubling after each attempt.
+	sessionCheckBackoff = time.Second
+)
+
+// checkSessions periodically confirms that each app's connection is still
+// approved by the indexer until ctx is canceled. An app whose approval was
+// revoked stops receiving uploads until it is approved again.
+func checkSessions(ctx context.Context, log *zap.Logger, apps []*appClient, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		for _, a := range apps {
+			if a.reconnecting.Load() {
+				// still waiting for the user to approve it again
+				continue
+			}
+			a.checkSession(ctx, log.With(zap.Int("app", a.index)))
+		}
+	}
+}
+
+func (a *appClient) checkSession(ctx context.Context, log *zap.Logger) {
+	start := time.Now()
+	resp, connected, err := sdk.Connect(ctx, indexerURL, a.sk, registerRequest)
+	for attempt := 1; err != nil && attempt <= sessionCheckRetries && isTransient(err); attempt++ {
+		log.Debug("session check failed, retrying", zap.Int("attempt", attempt), zap.Error(err))
+		if !waitFor(ctx, sessionCheckBackoff<<(attempt-1)) {
+			return
+		}
+		resp, connected, err = sdk.Connect(ctx, indexerURL, a.sk, registerRequest)
+	}
+	if ctx.Err() != nil {
+		return
+	} else if err != nil {
+		// the session may still be valid, so the client is kept
+		log.Warn("session check failed", zap.Error(err))
+		return
+	} else if connected {
+		log.Debug("session check passed", zap.Duration("duration", time.Since(start)))
+		return
+	}
+
+	a.healthy.Store(false)
+	a.reconnecting.Store(true)
+	log.Warn("app connection is no longer approved, uploads will skip it until it is approved again", zap.String("url", resp.ResponseURL))
+	go func() {
+		defer a.reconnecting.Store(false)
+		a.reapprove(ctx, log, resp.WaitForApproval)
+	}()
+}
+
+// reapprove waits for the user to approve the app again and replaces its SDK
+// client with one for the new session.
+func (a *appClient) reapprove(ctx context.Context, log *zap.Logger, waitForApproval func(context.Context) (bool, error)) {
+	if connected, err := waitForApproval(ctx); ctx.Err() != nil {
+		return
+	} else if err != nil {
+		log.Warn("failed to wait for app approval", zap.Error(err))
+		return
+	} else if !connected {
+		log.Warn("user denied app connection, uploads will continue to skip it")
+		return
+	}
+
+	client, err := sdk.NewSDK(indexerURL, a.sk, sdk.WithLogger(log.Named("sdk")))
+	if err != nil {
+		log.Warn("failed to create SDK client", zap.Error(err))
+		return
+	}
+	a.client.Store(client)
+	a.healthy.Store(true)
+	log.Info("app approved again, resuming uploads")
+}
+
+// pickApp returns the next app in round-robin order that is still approved.
+// If none are, the next app is returned anyway so its uploads fail and are
+// counted rather than stalling the run.
+func pickApp(apps []*appClient, next *atomic.Uint64) *appClient {
+	n := next.Add(1) - 1
+	for i := range uint64(len(apps)) {
+		if a := apps[(n+i)%uint64(len(apps))]; a.healthy.Load() {
+			return a
+		}
+	}
+	return apps[n%uint64(len(apps))]
+}
+
+// isTransient reports whether a startup error may succeed if retried, such
+// as the indexer not accepting connections yet.
+func isTransient(err error) bool {
+	switch uploadErrorType(err) {
+	case "network", "timeout":
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// maxStartupBackoff caps the delay between startup retries.
+const maxStartupBackoff = 5 * time.Minute
+
+// startupDelay returns the delay before the given startup retry attempt,
+// starting at -startup.backoff and doubling after each attempt. Doubling
+// stops at maxStartupBackoff so many retries cannot overflow the delay.
+func startupDelay(attempt int) time.Duration {
+	backoff := startupBackoff
+	for i := 1; i < attempt && backoff < maxStartupBackoff; i++ {
+		backoff = min(2*backoff, maxStartupBackoff)
+	}
+	return backoff
+}
+
+// retryStartup reports whether a startup request that failed with err
+// should be retried, waiting before returning true. Only transient errors
+// are retried, up to -startup.retries times, with a delay from
+// startupDelay.
+func retryStartup(ctx context.Context, log *zap.Logger, op string, attempt int, err error) bool {
+	if attempt > startupRetries || !isTransient(err) {
+		return false
+	}
+	backoff := startupDelay(attempt)
+	log.Warn("startup request failed, retrying", zap.String("op", op), zap.Int("attempt", attempt), zap.Int("retries", startupRetries), zap.Duration("backoff", backoff), zap.Error(err))
+	return waitFor(ctx, backoff)
+}
+
+// logAppSummary logs the uploads, failures, and throughput of each app
+// identity over the elapsed run time. With -apps each identity acts as a
+// separate tenant of the indexer.
+func logAppSummary(log *zap.Logger, apps []*appClient, elapsed time.Duration) {
+	for _, a := range apps {
+		log.Info("app summary", zap.Int("app", a.index), zap.Int64("uploads", a.uploads.Load()), zap.Int64("failures", a.failures.Load()), zap.String("throughput", formatBpsString(a.redundantBytes.Load(), elapsed)))
+	}
+}
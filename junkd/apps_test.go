@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartupDelay(t *testing.T) {
+	defer func(d time.Duration) { startupBackoff = d }(startupBackoff)
+	startupBackoff = 2 * time.Second
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{5, 32 * time.Second},
+		{9, maxStartupBackoff},
+		{100, maxStartupBackoff},
+	}
+	for _, tt := range tests {
+		if got := startupDelay(tt.attempt); got != tt.want {
+			t.Errorf("startupDelay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestPickAppSkipsUnhealthy(t *testing.T) {
+	apps := make([]*appClient, 3)
+	for i := range apps {
+		apps[i] = &appClient{index: i}
+		apps[i].healthy.Store(true)
+	}
+	apps[1].healthy.Store(false)
+
+	var next atomic.Uint64
+	for i, want := range []int{0, 2, 2, 0, 2, 2} {
+		if got := pickApp(apps, &next).index; got != want {
+			t.Fatalf("pick %d: expected app %d, got %d", i, want, got)
+		}
+	}
+
+	// with no healthy apps, uploads still go round-robin
+	apps[0].healthy.Store(false)
+	apps[2].healthy.Store(false)
+	next.Store(0)
+	for i, want := range []int{0, 1, 2} {
+		if got := pickApp(apps, &next).index; got != want {
+			t.Fatalf("pick %d: expected app %d, got %d", i, want, got)
+		}
+	}
+}
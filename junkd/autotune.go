@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// autotuneStep is the measured throughput at one concurrency level.
+type autotuneStep struct {
+	Threads int
+	Bytes   int64
+	Window  time.Duration
+}
+
+func (s autotuneStep) bytesPerSecond() float64 {
+	return float64(s.Bytes) / s.Window.Seconds()
+}
+
+// autotune doubles the number of workers in the pool, measuring the
+// aggregate throughput at each level, until throughput stops improving by at
+// least the configured threshold. The pool is left running at the best level.
+func autotune(ctx context.Context, log *zap.Logger, pool *workerPool, stats *uploadStats) (best autotuneStep, sweep []autotuneStep) {
+	for n := 1; n <= autotuneMax; n *= 2 {
+		pool.Resize(n)
+		log.Info("measuring concurrency level", zap.Int("threads", n), zap.Duration("window", autotuneWindow))
+
+		startBytes := stats.totalRedundantBytes()
+		start := time.Now()
+		if ok := waitFor(ctx, autotuneWindow); !ok {
+			return
+		}
+		step := autotuneStep{
+			Threads: n,
+			Bytes:   stats.totalRedundantBytes() - startBytes,
+			Window:  time.Since(start),
+		}
+		sweep = append(sweep, step)
+		log.Info("concurrency level measured", zap.Int("threads", n), zap.String("throughput", formatBpsString(step.Bytes, step.Window)))
+
+		if best.Threads != 0 && step.bytesPerSecond() < best.bytesPerSecond()*(1+autotuneThreshold) {
+			break
+		}
+		best = step
+	}
+
+	pool.Resize(best.Threads)
+	return
+}
+
+func printAutotuneSweep(best autotuneStep, sweep []autotuneStep) {
+	tw := tabwriter.NewWriter(humanOutput(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "THREADS\tTHROUGHPUT\tBEST")
+	for _, step := range sweep {
+		var marker string
+		if step.Threads == best.Threads {
+			marker = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", step.Threads, formatBpsString(step.Bytes, step.Window), marker)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (bs breakerState) String() string {
+	switch bs {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// A circuitBreaker pauses all uploads when the failure ratio of recent
+// uploads exceeds a threshold. After a cooldown a single probe upload is
+// allowed; uploads resume if it succeeds.
+type circuitBreaker struct {
+	log       *zap.Logger
+	threshold float64
+	cooldown  time.Duration
+	window    int
+
+	mu       sync.Mutex
+	state    breakerState
+	outcomes []bool
+	openedAt time.Time
+	probing  bool
+}
+
+func (cb *circuitBreaker) transition(to breakerState, fields ...zap.Field) {
+	cb.log.Info("circuit breaker state changed", append([]zap.Field{zap.Stringer("from", cb.state), zap.Stringer("to", to)}, fields...)...)
+	cb.state = to
+}
+
+// Wait blocks until an upload may be started or the context is canceled.
+func (cb *circuitBreaker) Wait(ctx context.Context) error {
+	for {
+		cb.mu.Lock()
+		var wait time.Duration
+		switch cb.state {
+		case breakerClosed:
+			cb.mu.Unlock()
+			return nil
+		case breakerOpen:
+			wait = time.Until(cb.openedAt.Add(cb.cooldown))
+			if wait <= 0 {
+				cb.transition(breakerHalfOpen)
+				cb.probing = true
+				cb.mu.Unlock()
+				return nil
+			}
+		case breakerHalfOpen:
+			if !cb.probing {
+				cb.probing = true
+				cb.mu.Unlock()
+				return nil
+			}
+			wait = time.Second
+		}
+		cb.mu.Unlock()
+
+		if !waitFor(ctx, wait) {
+			return ctx.Err()
+		}
+	}
+}
+
+// Release ends an upload started after Wait without recording an outcome,
+// such as when the data source is exhausted or the upload was canceled for
+// being slow. If the upload was the half-open probe, another probe is
+// allowed.
+func (cb *circuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == breakerHalfOpen {
+		cb.probing = false
+	}
+}
+
+// Record records the outcome of an upload.
+func (cb *circuitBreaker) Record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerHalfOpen:
+		cb.probing = false
+		if success {
+			cb.outcomes = cb.outcomes[:0]
+			cb.transition(breakerClosed)
+		} else {
+			cb.openedAt = time.Now()
+			cb.transition(breakerOpen, zap.Duration("cooldown", cb.cooldown))
+		}
+	case breakerClosed:
+		cb.outcomes = append(cb.outcomes, success)
+		if len(cb.outcomes) > cb.window {
+			cb.outcomes = cb.outcomes[len(cb.outcomes)-cb.window:]
+		}
+		if len(cb.outcomes) < cb.window {
+			return
+		}
+
+		var failures int
+		for _, ok := range cb.outcomes {
+			if !ok {
+				failures++
+			}
+		}
+		if ratio := float64(failures) / float64(len(cb.outcomes)); ratio > cb.threshold {
+			cb.openedAt = time.Now()
+			cb.transition(breakerOpen, zap.Float64("failureRatio", ratio), zap.Duration("cooldown", cb.cooldown))
+		}
+	}
+}
+
+func newCircuitBreaker(log *zap.Logger, threshold float64, cooldown time.Duration, window int) *circuitBreaker {
+	return &circuitBreaker{
+		log:       log,
+		threshold: threshold,
+		cooldown:  cooldown,
+		window:    window,
+	}
+}
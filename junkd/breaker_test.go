@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	cb := newCircuitBreaker(zap.NewNop(), 0.5, time.Millisecond, 2)
+	cb.Record(false)
+	cb.Record(false)
+	if cb.state != breakerOpen {
+		t.Fatalf("expected open breaker, got %v", cb.state)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cb.Wait(ctx); err != nil {
+		t.Fatal(err)
+	} else if cb.state != breakerHalfOpen || !cb.probing {
+		t.Fatalf("expected half-open breaker with a probe, got %v (probing %v)", cb.state, cb.probing)
+	}
+
+	// a second upload must wait for the probe
+	blocked, cancelBlocked := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelBlocked()
+	if err := cb.Wait(blocked); err == nil {
+		t.Fatal("expected Wait to block while probing")
+	}
+
+	// releasing the probe without an outcome allows another probe
+	cb.Release()
+	if err := cb.Wait(ctx); err != nil {
+		t.Fatal(err)
+	} else if cb.state != breakerHalfOpen {
+		t.Fatalf("expected half-open breaker, got %v", cb.state)
+	}
+
+	cb.Record(true)
+	if cb.state != breakerClosed || cb.probing {
+		t.Fatalf("expected closed breaker, got %v (probing %v)", cb.state, cb.probing)
+	}
+}
+
+func TestCircuitBreakerProbeFailure(t *testing.T) {
+	cb := newCircuitBreaker(zap.NewNop(), 0, time.Millisecond, 1)
+	cb.Record(false)
+	time.Sleep(2 * time.Millisecond)
+	if err := cb.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cb.Record(false)
+	if cb.state != breakerOpen || cb.probing {
+		t.Fatalf("expected reopened breaker, got %v (probing %v)", cb.state, cb.probing)
+	}
+}
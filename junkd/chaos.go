@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A threadChange is a change in the number of active workers made by
+// -chaos.threads.
+type threadChange struct {
+	Time    time.Time
+	Threads int
+}
+
+// chaosThreads resizes the pool to a random number of workers within r every
+// interval, simulating fluctuating client load. It returns the thread count
+// timeline when ctx is canceled or all workers have stopped on their own.
+func chaosThreads(ctx context.Context, log *zap.Logger, pool *workerPool, r countRange, interval time.Duration) (timeline []threadChange) {
+	for {
+		n := r.sample()
+		pool.Resize(n)
+		timeline = append(timeline, threadChange{Time: time.Now(), Threads: n})
+		log.Debug("resized worker pool", zap.Int("threads", n))
+
+		if !waitFor(ctx, interval) || pool.Size() == 0 {
+			return
+		}
+	}
+}
+
+// logThreadTimeline logs each thread count change and the time-weighted mean
+// number of threads between the first change and end.
+func logThreadTimeline(log *zap.Logger, timeline []threadChange, end time.Time) {
+	if len(timeline) == 0 {
+		return
+	}
+	start := timeline[0].Time
+	var weighted float64
+	for i, tc := range timeline {
+		until := end
+		if i+1 < len(timeline) {
+			until = timeline[i+1].Time
+		}
+		weighted += float64(tc.Threads) * until.Sub(tc.Time).Seconds()
+		log.Info("thread count timeline", zap.Duration("offset", tc.Time.Sub(start).Round(time.Second)), zap.Int("threads", tc.Threads), zap.Duration("held", until.Sub(tc.Time).Round(time.Second)))
+	}
+	var mean float64
+	if elapsed := end.Sub(start).Seconds(); elapsed > 0 {
+		mean = weighted / elapsed
+	}
+	log.Info("chaos thread summary", zap.Int("changes", len(timeline)), zap.Float64("meanThreads", mean))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// parseShardConfig parses a shard configuration written as "data-of-total",
+// the same format as shardConfig.String.
+func parseShardConfig(s string) (shardConfig, error) {
+	d, n, ok := strings.Cut(s, "-of-")
+	if !ok {
+		return shardConfig{}, fmt.Errorf("expected data-of-total, got %q", s)
+	}
+	data, err := strconv.Atoi(d)
+	if err != nil {
+		return shardConfig{}, fmt.Errorf("invalid data shards %q", d)
+	}
+	total, err := strconv.Atoi(n)
+	if err != nil {
+		return shardConfig{}, fmt.Errorf("invalid total shards %q", n)
+	}
+	sc := shardConfig{Data: data, Parity: total - data}
+	if err := sc.validate(); err != nil {
+		return shardConfig{}, err
+	}
+	return sc, nil
+}
+
+// compareStats are the results of one side of a comparison.
+type compareStats struct {
+	phaseStats
+	Failures  int
+	durations []time.Duration
+}
+
+// add adds a completed upload, keeping the most recent durations for the
+// percentiles.
+func (cs *compareStats) add(size, redundant int64, d time.Duration) {
+	cs.phaseStats.add(size, redundant, d)
+	cs.durations = append(cs.durations, d)
+	if len(cs.durations) > maxWindowSamples {
+		cs.durations = cs.durations[len(cs.durations)-maxWindowSamples:]
+	}
+}
+
+// percentiles returns the p50, p90, and p99 of the recent durations, or
+// zeros if there are too few samples.
+func (cs *compareStats) percentiles() (p50, p90, p99 time.Duration) {
+	if len(cs.durations) < minPercentileSamples {
+		return 0, 0, 0
+	}
+	sorted := slices.Sorted(slices.Values(cs.durations))
+	return percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99)
+}
+
+// printCompareTable writes a side-by-side summary of each side of a
+// comparison to w. The first column is titled column and holds names.
+func printCompareTable(w io.Writer, column string, names []string, stats []compareStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tUPLOADS\tFAILURES\tAVERAGE SPEED\tMEAN\tP50\tP90\tP99\n", column)
+	for i, cs := range stats {
+		p50, p90, p99 := cs.percentiles()
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", names[i], cs.Uploads, cs.Failures, cs.speed(), cs.meanDuration(), p50, p90, p99)
+	}
+	tw.Flush()
+}
+
+// A shardComparison alternates uploads between two shard configurations so
+// both are measured under the same indexer conditions.
+type shardComparison struct {
+	configs [2]shardConfig
+	next    atomic.Uint64
+
+	mu    sync.Mutex
+	stats [2]compareStats
+}
+
+// pick returns the configuration for the next upload.
+func (cmp *shardComparison) pick() shardConfig {
+	return cmp.configs[(cmp.next.Add(1)-1)%2]
+}
+
+func (cmp *shardComparison) side(sc shardConfig) *compareStats {
+	if sc == cmp.configs[0] {
+		return &cmp.stats[0]
+	}
+	return &cmp.stats[1]
+}
+
+// record adds a completed upload made with sc.
+func (cmp *shardComparison) record(sc shardConfig, size, redundant int64, d time.Duration) {
+	cmp.mu.Lock()
+	defer cmp.mu.Unlock()
+	cmp.side(sc).add(size, redundant, d)
+}
+
+// recordFailure adds a failed upload made with sc.
+func (cmp *shardComparison) recordFailure(sc shardConfig) {
+	cmp.mu.Lock()
+	defer cmp.mu.Unlock()
+	cmp.side(sc).Failures++
+}
+
+// printSummary writes a side-by-side summary of both configurations to w.
+func (cmp *shardComparison) printSummary(w io.Writer) {
+	cmp.mu.Lock()
+	defer cmp.mu.Unlock()
+
+	printCompareTable(w, "CONFIG", []string{cmp.configs[0].String(), cmp.configs[1].String()}, cmp.stats[:])
+}
+
+func newShardComparison(a, b shardConfig) *shardComparison {
+	return &shardComparison{configs: [2]shardConfig{a, b}}
+}
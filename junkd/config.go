@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// A parsedConfig holds the values parsed while validating the flags, so
+// files named by the flags are only read once.
+type parsedConfig struct {
+	shards shardConfig
+	// secret is the contents of -app.secret-file, if set
+	secret secretFile
+	// replay is the schedule loaded from -replay, if set
+	replay *replaySchedule
+	// sizes is the distribution loaded from -size.dist, if set
+	sizes *sizeDist
+}
+
+// validateConfig returns the configuration parsed from the flags and every
+// problem found with them, so a bad configuration can be reported in full
+// before junkd connects to the indexer. It does not modify the flag values.
+func validateConfig(parityFlagSet bool) (cfg parsedConfig, errs []error) {
+	check := func(failed bool, msg string) {
+		if failed {
+			errs = append(errs, errors.New(msg))
+		}
+	}
+
+	var err error
+	if cfg.shards, err = resolveShards(parityFlagSet); err != nil {
+		errs = append(errs, fmt.Errorf("invalid shard configuration: %w", err))
+	}
+
+	// -chaos.threads replaces -threads when set
+	checkThreads := func() {
+		check(chaosRange.Max == 0 && threads < 1, "-threads must be at least 1")
+	}
+	switch mode {
+	case "selftest":
+	case "upload":
+		checkThreads()
+	case "autotune":
+		check(autotuneMax < 1, "-autotune.max must be at least 1")
+		check(autotuneWindow <= 0, "-autotune.window must be positive")
+		check(autotuneThreshold < 0, "-autotune.threshold must not be negative")
+	case "compare":
+		checkThreads()
+		check(compareA == nil || compareB == nil, "-mode=compare requires -compare.a and -compare.b")
+		check(compareA != nil && compareB != nil && *compareA == *compareB, "-compare.a and -compare.b must be different")
+		check(shardsRandom, "-mode=compare cannot be combined with -shards.random")
+	case "dedup":
+		checkThreads()
+		check(sourcePath != "", "-mode=dedup generates its own data and cannot be used with -source")
+		check(sizeDistPath != "", "-mode=dedup cannot be combined with -size.dist")
+		check(shardsRandom, "-mode=dedup cannot be combined with -shards.random")
+	case "encode-bench":
+		check(threads < 1, "-threads must be at least 1")
+		check(encodeDuration <= 0, "-encode.duration must be positive")
+		check(sourcePath == "-", "-mode=encode-bench cannot read from stdin")
+	default:
+		errs = append(errs, fmt.Errorf("unknown mode %q", mode))
+	}
+	check(chaosRange.Max > 0 && chaosRange.Min < 1, "-chaos.threads minimum must be at least 1")
+	check(chaosRange.Max > 0 && mode != "upload" && mode != "compare", "-chaos.threads requires -mode=upload or -mode=compare")
+	check(chaosRange.Max > 0 && chaosInterval <= 0, "-chaos.interval must be positive")
+	if mode == "selftest" {
+		// the self-test runs locally, so the remaining flags are unused
+		return cfg, errs
+	}
+
+	// the encode benchmark only uses the data source and shard flags
+	if mode != "encode-bench" {
+		indexer := indexerURL
+		if secretPath != "" {
+			if cfg.secret, err = loadSecretFile(secretPath); err != nil {
+				errs = append(errs, err)
+			} else if cfg.secret.IndexerURL != "" {
+				indexer = cfg.secret.IndexerURL
+			}
+		}
+		if _, err := normalizeURL(indexer); err != nil {
+			errs = append(errs, err)
+		}
+		check(secretStdin && sourcePath == "-", "-app.secret-stdin cannot be used with -source=-")
+		check(appCount < 1, "-apps must be at least 1")
+		check(sessionCheckInterval < 0, "-session.check-interval must not be negative")
+		check(startupRetries < 0, "-startup.retries must not be negative")
+		check(startupRetries > 0 && startupBackoff <= 0, "-startup.backoff must be positive")
+	}
+
+	check(sourcePath != "" && seed != "", "-seed cannot be used with -source")
+	check(pregenCount < 0, "-pregen.count must not be negative")
+	check(pregenCount > 0 && (sourcePath != "" || seed != ""), "-pregen.count cannot be used with -source or -seed")
+	if sourcePath == "" && seed == "" {
+		check(randSource != "frand" && randSource != "crypto", fmt.Sprintf("unknown random source %q", randSource))
+	}
+	check(entropy < 0 || entropy > 1, "-entropy must be between 0 and 1")
+	check(entropy < 1 && sourcePath != "", "-entropy cannot be used with -source")
+	// seeded uploads must stay reproducible from the seed alone
+	check(entropy < 1 && seed != "", "-entropy cannot be used with -seed")
+	switch shutdownMode {
+	case shutdownDrain, shutdownDrainDropQueued, shutdownCancel:
+	default:
+		errs = append(errs, fmt.Errorf("unknown shutdown mode %q", shutdownMode))
+	}
+	check(len(affinityCPUs) > 0 && !affinitySupported, "-affinity is only supported on Linux")
+	check(uploadCount < 0, "-count must not be negative")
+	check(limitBytes < 0, "-limit.bytes must not be negative")
+	check(replayPath != "" && sizeDistPath != "", "-replay cannot be combined with -size.dist")
+	check(replayPath != "" && mode == "dedup", "-replay cannot be used with -mode=dedup")
+	check(replayMaxGap < 0, "-replay.max-gap must not be negative")
+	check(sizeFloor < 0, "-size.floor must not be negative")
+	if replayPath != "" {
+		if cfg.replay, err = loadReplay(replayPath, replayMaxGap); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if sizeDistPath != "" {
+		if cfg.sizes, err = loadSizeDist(sizeDistPath); err != nil {
+			errs = append(errs, fmt.Errorf("invalid -size.dist: %w", err))
+		}
+	}
+
+	if adminAddr != "" {
+		if host, _, err := net.SplitHostPort(adminAddr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid -admin.addr: %w", err))
+		} else {
+			check(!isLoopbackHost(host) && adminToken == "", "-admin.addr must be a loopback address unless -admin.token is set")
+		}
+		check(adminMaxWorkers < 1, "-admin.max-workers must be at least 1")
+	}
+
+	check(httpResponseHeaderTimeout < 0, "-http.response-header-timeout must not be negative")
+	if webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid -webhook.url %q", webhookURL))
+		}
+	}
+	if statsdAddr != "" {
+		if _, _, err := net.SplitHostPort(statsdAddr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid -statsd.addr: %w", err))
+		}
+	}
+
+	check(outputHash && outputPath == "", "-output.hash requires -output")
+	check(statsStatePath != "" && statsStateInterval <= 0, "-stats.state.interval must be positive")
+	check(timelineWidth <= 0, "-timeline.bucket must be positive")
+	if heatmapCSVPath != "" {
+		check(heatmapWidth <= 0 || heatmapLatencyWidth <= 0, "-heatmap.time-bucket and -heatmap.latency-bucket must be positive")
+		check(heatmapLatencyBuckets < 2, "-heatmap.latency-buckets must be at least 2")
+	}
+
+	check(batchSize < 1, "-batch must be at least 1")
+	check(uploadTimeout < 0 || uploadTimeoutPerMB < 0, "-upload.timeout and -upload.timeout-per-mb must not be negative")
+	check(cancelFactor != 0 && cancelFactor <= 1, "-cancel.factor must be greater than 1")
+	if shardsRandom {
+		check(redundancy > 0 || noRedundancy, "-shards.random cannot be combined with -redundancy or -no-redundancy")
+		if _, err := newRandomShards(shardsRandomData, shardsRandomParity); err != nil {
+			errs = append(errs, fmt.Errorf("invalid random shard ranges: %w", err))
+		}
+	}
+
+	check(uploadRate < 0, "-rate must not be negative")
+	check(threadRate < 0, "-throttle.per-thread must not be negative")
+	check(retryBudget < 0, "-retry.budget must not be negative")
+	check(uploadRetries < 0, "-upload.retries must not be negative")
+	check(breakerThreshold < 0 || breakerThreshold >= 1, "-breaker.threshold must be in [0, 1)")
+	check(breakerThreshold > 0 && breakerWindow < 1, "-breaker.window must be at least 1")
+	return cfg, errs
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestValidateConfigLeavesFlagsUnchanged(t *testing.T) {
+	defer func(v bool) { noRedundancy = v }(noRedundancy)
+	noRedundancy = true
+
+	before := shards
+	cfg, _ := validateConfig(false)
+	if cfg.shards.Parity != 0 || cfg.shards.Data != before.Data {
+		t.Fatalf("expected %d-of-%d, got %v", before.Data, before.Data, cfg.shards)
+	} else if shards != before {
+		t.Fatalf("validateConfig changed the shard configuration from %v to %v", before, shards)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A deadLetter describes an upload that failed after exhausting its retries.
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	RunID    string    `json:"runID"`
+	Thread   string    `json:"thread"`
+	Index    uint64    `json:"index"`
+	Size     int64     `json:"size"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+}
+
+// A deadLetterWriter appends a JSON line for every upload that permanently
+// failed.
+type deadLetterWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func (dw *deadLetterWriter) Write(dl deadLetter) error {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if err := dw.enc.Encode(dl); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+func (dw *deadLetterWriter) Close() error {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	return dw.f.Close()
+}
+
+func openDeadLetterWriter(path string) (*deadLetterWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	return &deadLetterWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
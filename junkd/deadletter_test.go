@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletters.jsonl")
+	dw, err := openDeadLetterWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		dl := deadLetter{Time: time.Now(), Thread: "upload-thread-1", Index: uint64(i), Size: 10, Attempts: 2, Error: "failed"}
+		if err := dw.Write(dl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var n int
+	for s := bufio.NewScanner(f); s.Scan(); n++ {
+		var dl deadLetter
+		if err := json.Unmarshal(s.Bytes(), &dl); err != nil {
+			t.Fatal(err)
+		} else if dl.Index != uint64(n) || dl.Attempts != 2 {
+			t.Fatalf("unexpected dead letter %+v", dl)
+		}
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 dead letters, got %d", n)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+// dedupSpeedup is how much faster repeated uploads must be than random
+// uploads for the probe to report that the indexer appears to deduplicate.
+const dedupSpeedup = 1.5
+
+// A dedupProbe alternates uploads between the same content and fresh random
+// content so the indexer's handling of identical data can be compared with
+// unique data under the same conditions. It is the data source for
+// -mode=dedup.
+type dedupProbe struct {
+	src     dataSource
+	content []byte
+	next    atomic.Uint64
+
+	mu    sync.Mutex
+	stats [2]compareStats // repeated, random
+	// slabs counts the repeated uploads that returned each slab ID
+	slabs map[string]int
+	// shared is the number of repeated uploads that returned a slab that
+	// an earlier repeated upload also returned
+	shared int
+}
+
+func (dp *dedupProbe) Next(n int64) (chunk, error) {
+	i := dp.next.Add(1) - 1
+	if dp.repeated(i) {
+		open := func() io.Reader { return bytes.NewReader(dp.content) }
+		return chunk{Open: open, Size: int64(len(dp.content)), Index: i}, nil
+	}
+	c, err := dp.src.Next(n)
+	if err != nil {
+		return chunk{}, err
+	}
+	c.Index = i
+	return c, nil
+}
+
+// repeated reports whether the chunk at index i is the repeated content.
+func (dp *dedupProbe) repeated(i uint64) bool {
+	return i%2 == 0
+}
+
+func (dp *dedupProbe) side(c chunk) *compareStats {
+	if dp.repeated(c.Index) {
+		return &dp.stats[0]
+	}
+	return &dp.stats[1]
+}
+
+// record adds a completed upload of c that returned slabIDs.
+func (dp *dedupProbe) record(c chunk, slabIDs []string, redundant int64, d time.Duration) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	dp.side(c).add(c.Size, redundant, d)
+	if !dp.repeated(c.Index) {
+		return
+	}
+	var shared bool
+	for _, id := range slabIDs {
+		if dp.slabs[id] > 0 {
+			shared = true
+		}
+		dp.slabs[id]++
+	}
+	if shared {
+		dp.shared++
+	}
+}
+
+// recordFailure adds a failed upload of c.
+func (dp *dedupProbe) recordFailure(c chunk) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	dp.side(c).Failures++
+}
+
+// printSummary writes the throughput of repeated and random uploads and the
+// probe's findings to w.
+func (dp *dedupProbe) printSummary(w io.Writer) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	printCompareTable(w, "CONTENT", []string{"repeated", "random"}, dp.stats[:])
+}
+
+// logFindings logs whether the results suggest the indexer deduplicates
+// identical content.
+func (dp *dedupProbe) logFindings(log *zap.Logger) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	repeated, random := dp.stats[0], dp.stats[1]
+	if repeated.Uploads == 0 || random.Uploads == 0 {
+		log.Warn("not enough uploads to probe deduplication", zap.Int("repeated", repeated.Uploads), zap.Int("random", random.Uploads))
+		return
+	}
+	speedup := float64(random.meanDuration()) / float64(repeated.meanDuration())
+	fields := []zap.Field{
+		zap.Float64("speedup", speedup),
+		zap.Int("repeatedUploads", repeated.Uploads),
+		zap.Int("sharedSlabUploads", dp.shared),
+		zap.Int("distinctSlabs", len(dp.slabs)),
+	}
+	switch {
+	case dp.shared > 0:
+		log.Info("indexer deduplicates identical content, repeated uploads returned existing slabs", fields...)
+	case speedup >= dedupSpeedup:
+		log.Info("repeated uploads are faster than random uploads, the indexer may deduplicate identical content", fields...)
+	default:
+		log.Info("no sign of deduplication, repeated uploads returned new slabs at the same speed", fields...)
+	}
+}
+
+// newDedupProbe returns a probe that alternates between size bytes of fixed
+// random content and chunks from src.
+func newDedupProbe(src dataSource, size int64) *dedupProbe {
+	return &dedupProbe{
+		src:     src,
+		content: frand.Bytes(int(size)),
+		slabs:   make(map[string]int),
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	proto "go.sia.tech/core/rhp/v4"
+	"go.uber.org/zap"
+)
+
+// runEncodeBench erasure codes slabs from source using the configured shard
+// counts on -threads goroutines until -encode.duration elapses, the source is
+// exhausted, or ctx is canceled. The indexer is never contacted, so the
+// result is the client-side ceiling for upload throughput.
+func runEncodeBench(ctx context.Context, log *zap.Logger, source dataSource) error {
+	ctx, cancel := context.WithTimeout(ctx, encodeDuration)
+	defer cancel()
+
+	userStart, sysStart, cpuOK := cpuTime()
+	start := time.Now()
+
+	var slabs atomic.Int64
+	var wg sync.WaitGroup
+	errCh := make(chan error, threads)
+	for range threads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := encodeSlabs(ctx, source, &slabs); err != nil {
+				errCh <- err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	if err := <-errCh; err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	n := slabs.Load()
+	fields := []zap.Field{
+		zap.Stringer("redundancy", shards),
+		zap.Int("threads", threads),
+		zap.Int64("slabs", n),
+		zap.Duration("elapsed", elapsed),
+		zap.String("dataThroughput", formatBpsString(n*shards.slabSize(), elapsed)),
+		zap.String("redundantThroughput", formatBpsString(n*shards.redundantSlabSize(), elapsed)),
+	}
+	if cpuOK {
+		userEnd, sysEnd, _ := cpuTime()
+		user, sys := userEnd-userStart, sysEnd-sysStart
+		// utilization is in cores, so 2 means two CPUs were fully busy
+		fields = append(fields, zap.Duration("cpuUser", user), zap.Duration("cpuSystem", sys), zap.Float64("cpuUtilization", float64(user+sys)/float64(elapsed)))
+	}
+	log.Info("encode benchmark complete", fields...)
+	return nil
+}
+
+// encodeSlabs encodes slabs from source until ctx is canceled or the source
+// is exhausted, adding the number of encoded slabs to n.
+func encodeSlabs(ctx context.Context, source dataSource, n *atomic.Int64) error {
+	enc, err := reedsolomon.New(shards.Data, shards.Parity)
+	if err != nil {
+		return fmt.Errorf("failed to create encoder: %w", err)
+	}
+
+	buf := make([]byte, shards.redundantSlabSize())
+	sectors := make([][]byte, shards.Data+shards.Parity)
+	for i := range sectors {
+		sectors[i] = buf[i*proto.SectorSize : (i+1)*proto.SectorSize]
+	}
+	data := buf[:shards.slabSize()]
+
+	for ctx.Err() == nil {
+		c, err := source.Next(shards.slabSize())
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// pad a short final chunk with zeros, like a partial slab
+		read, err := io.ReadFull(c.Open(), data[:c.Size])
+		if err != nil {
+			return fmt.Errorf("failed to read slab data: %w", err)
+		}
+		clear(data[read:])
+		if err := enc.Encode(sectors); err != nil {
+			return fmt.Errorf("failed to encode slab: %w", err)
+		}
+		n.Add(1)
+	}
+	return nil
+}
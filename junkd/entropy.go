@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"math"
+)
+
+// entropyBlockSize is the size of the blocks an entropyReader splits its
+// output into. Each block starts with the random bytes followed by the
+// pattern, so the ratio holds within every block rather than only on
+// average.
+const entropyBlockSize = 256
+
+// entropyPattern is the repeated data mixed into low entropy uploads.
+var entropyPattern = []byte("junkd junk data ")
+
+// An entropyReader interleaves bytes from a random reader with a repeated
+// pattern so that approximately the entropy fraction of the output is
+// random. An entropy of 0 is entirely pattern and 1 is entirely random.
+type entropyReader struct {
+	r      io.Reader
+	random int // random bytes per block
+	off    int // offset within the current block
+}
+
+func (er *entropyReader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if er.off < er.random {
+			m, err := er.r.Read(p[n : n+min(len(p)-n, er.random-er.off)])
+			n += m
+			er.off += m
+			if err != nil {
+				return n, err
+			}
+		} else {
+			m := min(len(p)-n, entropyBlockSize-er.off)
+			for i := range m {
+				p[n+i] = entropyPattern[(er.off+i)%len(entropyPattern)]
+			}
+			n += m
+			er.off += m
+		}
+		if er.off == entropyBlockSize {
+			er.off = 0
+		}
+	}
+	return n, nil
+}
+
+func newEntropyReader(r io.Reader, entropy float64) *entropyReader {
+	return &entropyReader{r: r, random: int(math.Round(entropy * entropyBlockSize))}
+}
+
+// An entropySource limits the entropy of the chunks of a generated source.
+type entropySource struct {
+	src     dataSource
+	entropy float64
+}
+
+func (s *entropySource) Next(n int64) (chunk, error) {
+	c, err := s.src.Next(n)
+	if err != nil {
+		return chunk{}, err
+	}
+	open := c.Open
+	// the underlying reader produces at least as many random bytes as
+	// needed, so limit the output to the chunk's size
+	c.Open = func() io.Reader { return io.LimitReader(newEntropyReader(open(), s.entropy), c.Size) }
+	return c, nil
+}
+
+// withEntropy wraps a generated source to produce data with the configured
+// -entropy. Fully random data is returned unwrapped.
+func withEntropy(src dataSource) dataSource {
+	if entropy >= 1 {
+		return src
+	}
+	return &entropySource{src: src, entropy: entropy}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// An uploadEvent is written to stdout as a JSON line for every upload
+// attempt with -events.stdout.
+type uploadEvent struct {
+	Time     time.Time     `json:"time"`
+	Thread   string        `json:"thread"`
+	Index    uint64        `json:"index"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"duration"`
+	SlabID   string        `json:"slabID,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// An eventWriter writes upload events as newline-delimited JSON. Each event
+// is written with a single call to the underlying writer so concurrent
+// events are never interleaved.
+type eventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (ew *eventWriter) Write(ev uploadEvent) error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if err := ew.enc.Encode(ev); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+func newEventWriter(w io.Writer) *eventWriter {
+	return &eventWriter{enc: json.NewEncoder(w)}
+}
+
+// humanOutput returns the writer for logs and summary tables. Stdout is
+// reserved for the event stream with -events.stdout.
+func humanOutput() *os.File {
+	if eventsStdout {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Stable error codes reported for fatal startup failures.
+const (
+	codeInvalidConfig  = "invalid_config"
+	codeBadSecret      = "bad_secret"
+	codeConnectFailed  = "connect_failed"
+	codeApprovalFailed = "approval_failed"
+	codeApprovalDenied = "approval_denied"
+	codeSDKFailed      = "sdk_failed"
+	codeOutputFailed   = "output_failed"
+	codeEncodeFailed   = "encode_failed"
+	codeSelfTestFailed = "selftest_failed"
+)
+
+// A fatalError is written to stderr as a single JSON object when junkd
+// fails to start.
+type fatalError struct {
+	RunID   string    `json:"runID"`
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// fatalHooks are called before exiting on a fatal log entry, since deferred
+// calls in main are skipped.
+var fatalHooks []func()
+
+// lastFatal is the failure reported by fatal, included in the final report.
+var lastFatal *fatalError
+
+// fatalExit writes the final report and runs the fatal hooks before exiting.
+// It is installed as the logger's fatal hook so every call to Fatal cleans
+// up.
+type fatalExit struct{}
+
+func (fatalExit) OnWrite(ce *zapcore.CheckedEntry, _ []zapcore.Field) {
+	fe := lastFatal
+	if fe == nil {
+		// logged with Fatal directly rather than through fatal
+		fe = &fatalError{RunID: runID, Message: ce.Message, Time: ce.Time.UTC()}
+	}
+	setShutdownCause(causeFatal)
+	if err := writeFinalReport(fe); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write report:", err)
+	}
+	for _, fn := range fatalHooks {
+		fn()
+	}
+	os.Exit(1)
+}
+
+// fatal writes a structured error to stderr, then logs the failure and
+// exits.
+func fatal(log *zap.Logger, code, msg string, err error) {
+	fe := fatalError{
+		RunID:   runID,
+		Code:    code,
+		Message: msg,
+		Time:    time.Now().UTC(),
+	}
+	fields := []zap.Field{zap.String("code", code)}
+	if err != nil {
+		fe.Error = err.Error()
+		fields = append(fields, zap.Error(err))
+	}
+	json.NewEncoder(os.Stderr).Encode(fe)
+	if notifier != nil {
+		ev := newWebhookEvent("fatal", nil)
+		ev.Error = msg
+		if err != nil {
+			ev.Error += ": " + err.Error()
+		}
+		notifier.Close(ev)
+	}
+	setShutdownCause(causeFatal)
+	log.Error("shutting down", zap.String("cause", shutdownCause()), zap.String("code", code))
+	lastFatal = &fe
+	log.Fatal(msg, fields...)
+}
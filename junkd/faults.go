@@ -0,0 +1,89 @@
+//go:build faults
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// errInjectedFault is returned by uploads failed with the "error" fault.
+var errInjectedFault = errors.New("injected fault")
+
+// faults is the fault injection configuration set by -fault.inject.
+var faults struct {
+	mu    sync.Mutex
+	rate  float64
+	kind  string
+	count uint64
+}
+
+func init() {
+	flag.Func("fault.inject", "fail a fraction of uploads for testing, as rate[:kind] where kind is error, reset, or hang (default error)", parseFaults)
+}
+
+func parseFaults(s string) error {
+	r, kind, ok := strings.Cut(s, ":")
+	if !ok {
+		kind = "error"
+	}
+	rate, err := strconv.ParseFloat(r, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return fmt.Errorf("rate must be in [0, 1], got %q", r)
+	}
+	switch kind {
+	case "error", "reset", "hang":
+	default:
+		return fmt.Errorf("unknown fault kind %q", kind)
+	}
+	faults.rate, faults.kind = rate, kind
+	return nil
+}
+
+// shouldFault reports whether the next upload should fail. Faults are spread
+// evenly rather than randomly so a run fails exactly the configured fraction
+// of uploads.
+func shouldFault() bool {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+	if faults.rate == 0 {
+		return false
+	}
+	n := faults.count
+	faults.count++
+	return uint64(float64(n+1)*faults.rate) > uint64(float64(n)*faults.rate)
+}
+
+// A faultReader fails the upload reading from it.
+type faultReader struct {
+	ctx  context.Context
+	kind string
+}
+
+func (fr faultReader) Read([]byte) (int, error) {
+	switch fr.kind {
+	case "reset":
+		return 0, fmt.Errorf("%w: %w", errInjectedFault, syscall.ECONNRESET)
+	case "hang":
+		<-fr.ctx.Done()
+		return 0, context.Cause(fr.ctx)
+	default:
+		return 0, errInjectedFault
+	}
+}
+
+// injectFault returns a reader that fails the upload using it if the upload
+// was chosen for a fault, otherwise r is returned unchanged.
+func injectFault(ctx context.Context, r io.Reader) io.Reader {
+	if !shouldFault() {
+		return r
+	}
+	return faultReader{ctx: ctx, kind: faults.kind}
+}
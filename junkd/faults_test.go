@@ -0,0 +1,69 @@
+//go:build faults
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShouldFault(t *testing.T) {
+	if err := parseFaults("0.25"); err != nil {
+		t.Fatal(err)
+	}
+	faults.count = 0
+	var n int
+	for range 100 {
+		if shouldFault() {
+			n++
+		}
+	}
+	if n != 25 {
+		t.Fatalf("expected exactly 25 faults, got %d", n)
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	tests := []struct {
+		kind  string
+		check func(error) bool
+	}{
+		{"error", func(err error) bool { return errors.Is(err, errInjectedFault) }},
+		{"reset", func(err error) bool { return errors.Is(err, syscall.ECONNRESET) && uploadErrorType(err) == "network" }},
+		{"hang", func(err error) bool { return errors.Is(err, errUploadTimeout) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			if err := parseFaults("1:" + tt.kind); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeoutCause(context.Background(), 10*time.Millisecond, errUploadTimeout)
+			defer cancel()
+			_, err := io.ReadAll(injectFault(ctx, strings.NewReader("data")))
+			if !tt.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+
+	if err := parseFaults("0"); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := io.ReadAll(injectFault(context.Background(), strings.NewReader("data")))
+	if err != nil || string(buf) != "data" {
+		t.Fatalf("expected unmodified reader, got %q, %v", buf, err)
+	}
+}
+
+func TestParseFaults(t *testing.T) {
+	for _, s := range []string{"-0.1", "1.5", "x", "0.5:explode"} {
+		if err := parseFaults(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A latencyHeatmap is a 2D histogram of upload durations over time. Each row
+// is a fixed-width time bucket since the start of the run and each column a
+// fixed-width latency bucket. The final column counts all uploads slower than
+// the other columns. It is not safe for concurrent use.
+type latencyHeatmap struct {
+	start        time.Time
+	width        time.Duration
+	latencyWidth time.Duration
+	columns      int
+	rows         [][]int
+}
+
+func (hm *latencyHeatmap) add(t time.Time, d time.Duration) {
+	i := max(int(t.Sub(hm.start)/hm.width), 0)
+	for len(hm.rows) <= i {
+		hm.rows = append(hm.rows, make([]int, hm.columns))
+	}
+	j := min(int(d/hm.latencyWidth), hm.columns-1)
+	hm.rows[i][j]++
+}
+
+// writeCSV writes the heatmap as a grid with one row per time bucket.
+func (hm *latencyHeatmap) writeCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create heatmap file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	header := []string{"start", "offsetSeconds"}
+	for j := 1; j < hm.columns; j++ {
+		header = append(header, "<"+(time.Duration(j)*hm.latencyWidth).String())
+	}
+	header = append(header, ">="+(time.Duration(hm.columns-1)*hm.latencyWidth).String())
+	w.Write(header)
+	for i, row := range hm.rows {
+		offset := time.Duration(i) * hm.width
+		record := []string{
+			hm.start.Add(offset).Format(time.RFC3339),
+			strconv.FormatFloat(offset.Seconds(), 'f', -1, 64),
+		}
+		for _, n := range row {
+			record = append(record, strconv.Itoa(n))
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write heatmap: %w", err)
+	}
+	return f.Close()
+}
+
+func newLatencyHeatmap(start time.Time, width, latencyWidth time.Duration, columns int) *latencyHeatmap {
+	return &latencyHeatmap{
+		start:        start,
+		width:        width,
+		latencyWidth: latencyWidth,
+		columns:      columns,
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A phaseTiming aggregates the duration of one connection setup phase across
+// requests.
+type phaseTiming struct {
+	total atomic.Int64
+	count atomic.Int64
+}
+
+func (pt *phaseTiming) add(d time.Duration) {
+	pt.total.Add(int64(d))
+	pt.count.Add(1)
+}
+
+func (pt *phaseTiming) average() time.Duration {
+	n := pt.count.Load()
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(pt.total.Load() / n)
+}
+
+// A tracingTransport records DNS, connect, and TLS handshake timings and
+// connection reuse for every request made through it.
+type tracingTransport struct {
+	rt http.RoundTripper
+
+	dns     phaseTiming
+	connect phaseTiming
+	tls     phaseTiming
+
+	reusedConns atomic.Int64
+	newConns    atomic.Int64
+}
+
+func (tt *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var dnsStart, tlsStart time.Time
+	// dials to several addresses can race in parallel (RFC 6555), so each
+	// dial is timed separately
+	var mu sync.Mutex
+	connectStart := make(map[string]time.Time)
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tt.dns.add(time.Since(dnsStart))
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connectStart[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			start, ok := connectStart[network+" "+addr]
+			delete(connectStart, network+" "+addr)
+			mu.Unlock()
+			if ok && err == nil {
+				tt.connect.add(time.Since(start))
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				tt.reusedConns.Add(1)
+			} else {
+				tt.newConns.Add(1)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				tt.tls.add(time.Since(tlsStart))
+			}
+		},
+	}
+	return tt.rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func (tt *tracingTransport) logTimings(log *zap.Logger) {
+	log.Debug("connection setup timings",
+		zap.Duration("avgDNS", tt.dns.average()),
+		zap.Int64("dnsLookups", tt.dns.count.Load()),
+		zap.Duration("avgConnect", tt.connect.average()),
+		zap.Int64("connects", tt.connect.count.Load()),
+		zap.Duration("avgTLSHandshake", tt.tls.average()),
+		zap.Int64("tlsHandshakes", tt.tls.count.Load()))
+}
+
+// connections returns the number of requests that reused an idle connection
+// and the number that needed a new one.
+func (tt *tracingTransport) connections() (reused, fresh int64) {
+	return tt.reusedConns.Load(), tt.newConns.Load()
+}
+
+// logReuse logs the ratio of reused to new indexer API connections. Host
+// connections used for sector uploads are not counted. Low reuse at high
+// concurrency usually means the idle pool is too small for the number of
+// threads (see -http.max-conns-per-host).
+func (tt *tracingTransport) logReuse(log *zap.Logger) {
+	reused, fresh := tt.connections()
+	if reused+fresh == 0 {
+		return
+	}
+	log.Info("indexer API connection reuse", zap.Int64("reused", reused), zap.Int64("new", fresh), zap.Float64("reusePercent", reusePercent(reused, fresh)))
+}
+
+// reusePercent returns the percentage of requests that reused a connection.
+func reusePercent(reused, fresh int64) float64 {
+	if reused+fresh == 0 {
+		return 0
+	}
+	return 100 * float64(reused) / float64(reused+fresh)
+}
+
+// newTransport returns a clone of the default HTTP transport with the
+// configured connection pool settings applied.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		// a negative period disables TCP keep-alive probes
+		KeepAlive: httpKeepAlive,
+	}
+	t.DialContext = dialer.DialContext
+	t.DisableKeepAlives = httpDisableKeepAlives
+	if httpMaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = httpMaxConnsPerHost
+		t.MaxIdleConnsPerHost = httpMaxConnsPerHost
+	}
+	t.IdleConnTimeout = httpIdleTimeout
+	// only the indexer API requests use this transport, sector data is
+	// uploaded to hosts over the SDK's own connections
+	t.ResponseHeaderTimeout = httpResponseHeaderTimeout
+	if httpForceHTTP2 {
+		// only allow HTTP/2, using prior knowledge for unencrypted
+		// connections
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return t
+}
+
+// installTracingTransport replaces the default HTTP transport, which is used
+// by the SDK's indexer API client, with a tracing transport.
+//
+// Sector uploads to hosts do not go through this transport, so the HTTP
+// timeouts only apply to indexer API requests. The total time of an upload
+// is limited by its context instead (see -upload.timeout).
+func installTracingTransport(log *zap.Logger) *tracingTransport {
+	t := newTransport()
+	log.Info("configured HTTP transport", zap.Int("maxConnsPerHost", t.MaxConnsPerHost), zap.Int("maxIdleConnsPerHost", t.MaxIdleConnsPerHost), zap.Duration("idleTimeout", t.IdleConnTimeout), zap.Duration("responseHeaderTimeout", t.ResponseHeaderTimeout), zap.Bool("forceHTTP2", httpForceHTTP2), zap.Duration("keepAlive", httpKeepAlive), zap.Bool("disableKeepAlives", t.DisableKeepAlives))
+
+	tt := &tracingTransport{rt: t}
+	http.DefaultTransport = tt
+	return tt
+}
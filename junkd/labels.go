@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// labelNameRe matches valid metric label names.
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// A labelSet is the set of key=value labels attached to every log, metric,
+// and summary of a run. It is parsed from repeated -label flags.
+type labelSet map[string]string
+
+func (ls labelSet) String() string {
+	pairs := make([]string, 0, len(ls))
+	for _, k := range slices.Sorted(maps.Keys(ls)) {
+		pairs = append(pairs, k+"="+ls[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (ls labelSet) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", s)
+	} else if !labelNameRe.MatchString(k) {
+		return fmt.Errorf("invalid label name %q: must start with a letter or underscore and contain only letters, digits, and underscores", k)
+	} else if strings.HasPrefix(k, "__") {
+		return fmt.Errorf("invalid label name %q: names starting with __ are reserved", k)
+	} else if strings.ContainsAny(v, ",|#") {
+		// these separate tags and fields in statsd metric lines
+		return fmt.Errorf("invalid value for label %q: must not contain ',', '|', or '#'", k)
+	} else if _, ok := ls[k]; ok {
+		return fmt.Errorf("duplicate label %q", k)
+	}
+	ls[k] = v
+	return nil
+}
+
+// field returns the labels as a single log field, or zap.Skip if there are
+// none.
+func (ls labelSet) field() zap.Field {
+	if len(ls) == 0 {
+		return zap.Skip()
+	}
+	return zap.Any("labels", map[string]string(ls))
+}
+
+// statsdTags returns the labels as a DogStatsD tag suffix for metric lines,
+// or an empty string if there are none.
+func (ls labelSet) statsdTags() string {
+	if len(ls) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(ls))
+	for _, k := range slices.Sorted(maps.Keys(ls)) {
+		tags = append(tags, k+":"+ls[k])
+	}
+	return "|#" + strings.Join(tags, ",")
+}
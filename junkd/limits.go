@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errLimitReached is returned by nextUpload once the uploads needed to reach
+// -count or -limit.bytes have all been started.
+var errLimitReached = errors.New("upload limit reached")
+
+// uploadLimits bounds the number of objects and bytes uploaded in a run. A
+// zero limit is unlimited. Uploads are reserved when they are started so
+// concurrent workers never overshoot the limits.
+type uploadLimits struct {
+	count int64
+	bytes int64
+
+	mu           sync.Mutex
+	started      int64
+	startedBytes int64
+}
+
+// reserve claims the next upload of up to want bytes and returns its size,
+// which is truncated so the byte limit is reached exactly. It returns false
+// once a limit has been reached.
+func (ul *uploadLimits) reserve(want int64) (int64, bool) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	if ul.count > 0 && ul.started >= ul.count {
+		return 0, false
+	} else if ul.bytes > 0 {
+		if ul.startedBytes >= ul.bytes {
+			return 0, false
+		}
+		want = min(want, ul.bytes-ul.startedBytes)
+	}
+	ul.started++
+	ul.startedBytes += want
+	return want, true
+}
+
+// release returns part of a reservation, either because an upload was
+// abandoned or because the source returned less data than reserved.
+func (ul *uploadLimits) release(uploads, bytes int64) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	ul.started -= uploads
+	ul.startedBytes -= bytes
+}
+
+// eta estimates the time until the first limit is reached from the rate of
+// the most recent uploads. It returns false if the rate is not known yet.
+func (ul *uploadLimits) eta(stats *uploadStats) (time.Duration, bool) {
+	ops, ok := stats.windowOpsPerSecond()
+	if !ok || ops <= 0 {
+		return 0, false
+	}
+	warmup, measure := stats.phases()
+	uploads := int64(warmup.Uploads + measure.Uploads)
+	uploaded := warmup.Bytes + measure.Bytes
+
+	var eta time.Duration
+	var known bool
+	if ul.count > 0 {
+		eta = time.Duration(float64(max(ul.count-uploads, 0)) / ops * float64(time.Second))
+		known = true
+	}
+	if ul.bytes > 0 && uploads > 0 {
+		bytesPerSecond := ops * float64(uploaded) / float64(uploads)
+		d := time.Duration(float64(max(ul.bytes-uploaded, 0)) / bytesPerSecond * float64(time.Second))
+		if !known || d < eta {
+			eta = d
+		}
+		known = true
+	}
+	return eta, known
+}
+
+// etaString formats the estimated time to completion for the periodic stats
+// line.
+func (ul *uploadLimits) etaString(stats *uploadStats) string {
+	eta, ok := ul.eta(stats)
+	if !ok {
+		return "unknown"
+	}
+	return eta.Round(time.Second).String()
+}
@@ -4,155 +4,756 @@ import (
 	"context"
 	"crypto/pbkdf2"
 	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
+	"maps"
+	"net/url"
 	"os"
 	"os/signal"
-	"sync"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	proto "go.sia.tech/core/rhp/v4"
 	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils/wallet"
-	"go.sia.tech/indexd/api/app"
-	"go.sia.tech/indexd/sdk"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
 	"lukechampine.com/frand"
 )
 
-const (
-	dataShards        = 2
-	parityShards      = 4
-	slabSize          = dataShards * proto.SectorSize
-	redundantSlabSize = (dataShards + parityShards) * proto.SectorSize
-	redundancy        = (dataShards + parityShards) / dataShards
-)
-
 var (
-	appSecret  string
-	indexerURL string
-
-	logLevel zap.AtomicLevel
-	logPath  string
+	// shards is the erasure coding configuration used for uploads.
+	shards = shardConfig{Data: 2, Parity: 4}
 
-	threads int
+	appSecret   string
+	indexerURL  string
+	secretPath  string
+	secretStdin bool
+
+	configCheck bool
+
+	runID  string
+	labels = make(labelSet)
+
+	logLevel  zap.AtomicLevel
+	logPath   string
+	logNoHost bool
+
+	cpuProfilePath string
+	memProfilePath string
+
+	logSampleInitial    int
+	logSampleThereafter int
 
-	elapsedMu sync.Mutex
-	elapsed   []time.Duration
+	openApprovalBrowser bool
+
+	startupRetries int
+	startupBackoff time.Duration
+
+	threads  int
+	maxProcs int
+	appCount int
+
+	adminAddr       string
+	adminToken      string
+	adminMaxWorkers int
+
+	webhookURL   string
+	statsdAddr   string
+	webhookEvery int
+
+	outputPath     string
+	outputHash     bool
+	recordsPath    string
+	eventsStdout   bool
+	dbPath         string
+	deadLetterPath string
+
+	httpMaxConnsPerHost       int
+	httpIdleTimeout           time.Duration
+	httpResponseHeaderTimeout time.Duration
+	httpForceHTTP2            bool
+	httpKeepAlive             time.Duration
+	httpDisableKeepAlives     bool
+
+	randSource  string
+	entropy     float64
+	sourcePath  string
+	seed        string
+	pregenCount int
+
+	warmup time.Duration
+
+	shardsRandom       bool
+	shardsRandomData   = countRange{Min: 1, Max: 10}
+	shardsRandomParity = countRange{Min: 0, Max: 30}
+
+	noRedundancy bool
+	redundancy   float64
+	sizeDistPath string
+	sizeFloor    int64
+
+	replayPath   string
+	replayMaxGap time.Duration
+	batchSize    int
+	uploadRate   float64
+	threadRate   float64
+	retryBudget  int
+
+	breakerThreshold float64
+	breakerCooldown  time.Duration
+	breakerWindow    int
+
+	uploadTimeout      time.Duration
+	uploadTimeoutPerMB time.Duration
+	uploadRetries      int
+	failFast           bool
+	affinityCPUs       []int
+	shutdownMode       string
+	uploadCount        int64
+	limitBytes         int64
+
+	sessionCheckInterval time.Duration
+
+	stragglerFactor float64
+	cancelFactor    float64
+
+	goroutineThreshold int
+
+	timelineWidth   time.Duration
+	timelineCSVPath string
+
+	heatmapCSVPath        string
+	heatmapWidth          time.Duration
+	heatmapLatencyWidth   time.Duration
+	heatmapLatencyBuckets int
+	summaryCSVPath        string
+	reportPath            string
+	noSummary             bool
+
+	mode              string
+	autotuneMax       int
+	autotuneWindow    time.Duration
+	autotuneThreshold float64
+	autotuneExit      bool
+
+	encodeDuration time.Duration
+
+	chaosRange    countRange
+	chaosInterval time.Duration
+
+	compareA, compareB *shardConfig
+
+	statsStatePath     string
+	statsStateInterval time.Duration
+	statsStateMaxAge   time.Duration
 )
 
 func init() {
 	flag.StringVar(&indexerURL, "indexer.url", "http://localhost:9982", "the URL of the indexer API")
 	flag.StringVar(&appSecret, "app.secret", "", "a secret used to derive the application key")
+	flag.StringVar(&runID, "run.id", "", "an identifier for the run included in logs and reports (defaults to a random UUID)")
+	flag.BoolVar(&secretStdin, "app.secret-stdin", false, "read the app secret from the first line of stdin")
+	flag.StringVar(&secretPath, "secret.file", "", "the path of a JSON or KEY=VALUE file containing APP_SECRET and optionally INDEXER_URL, overriding the flags")
 
 	flag.TextVar(&logLevel, "log.level", zap.NewAtomicLevelAt(zap.InfoLevel), "the log level to use")
 	flag.StringVar(&logPath, "log.path", "", "the path to write the log to")
+	flag.StringVar(&cpuProfilePath, "pprof.cpu", "", "the path to write a CPU profile of the whole run to")
+	flag.StringVar(&memProfilePath, "pprof.mem", "", "the path to write a heap profile to at shutdown")
+	flag.Var(labels, "label", "a key=value label to attach to logs, metrics, and reports (can be repeated)")
+	flag.BoolVar(&logNoHost, "log.no-host", false, "omit the hostname and PID from log entries")
+	flag.Func("log.sample", "sample repetitive info and debug logs as initial:thereafter per second, e.g. 100:100 (warnings and errors are never sampled)", parseLogSample)
 
-	flag.IntVar(&threads, "threads", 1, "the number of upload threads")
+	flag.BoolVar(&openApprovalBrowser, "app.open-browser", false, "open the app approval URL in the default browser when a display is available")
 
-	flag.Parse()
+	flag.IntVar(&startupRetries, "startup.retries", 0, "the number of times to retry connecting to the indexer on transient failures at startup")
+	flag.DurationVar(&startupBackoff, "startup.backoff", 2*time.Second, "the delay before the first startup retry, doubling after each attempt up to 5m")
+
+	flag.BoolVar(&configCheck, "config.check", false, "validate the flags and secret file, report any problems, and exit without connecting")
+
+	flag.StringVar(&mode, "mode", "upload", "the mode to run in (upload, autotune, compare, dedup, selftest, encode-bench)")
+	flag.Var(&chaosRange, "chaos.threads", "randomly vary the number of threads within min:max over the run (off by default)")
+	flag.DurationVar(&chaosInterval, "chaos.interval", 30*time.Second, "how often to change the number of threads with -chaos.threads")
+	flag.DurationVar(&encodeDuration, "encode.duration", 30*time.Second, "how long to encode slabs for with -mode=encode-bench")
+	flag.IntVar(&threads, "threads", 1, "the number of upload threads")
+	flag.StringVar(&adminAddr, "admin.addr", "", "the address to serve the admin API on for adjusting the number of workers; a missing host listens on localhost, other hosts require -admin.token")
+	flag.StringVar(&adminToken, "admin.token", "", "a bearer token required by every admin API request")
+	flag.IntVar(&adminMaxWorkers, "admin.max-workers", 256, "the maximum number of workers the admin API can increase to")
+	flag.StringVar(&statsdAddr, "statsd.addr", "", "the host:port of a statsd server to send upload metrics to over UDP")
+	flag.StringVar(&webhookURL, "webhook.url", "", "a URL to POST JSON events to on run start, milestones, run end, and fatal errors")
+	flag.IntVar(&webhookEvery, "webhook.every", 1000, "the number of uploads between milestone webhook events (0 disables milestones)")
+	flag.IntVar(&appCount, "apps", 1, "the number of app identities to upload with, spreading uploads across them round-robin")
+	flag.IntVar(&maxProcs, "maxprocs", 0, "the maximum number of CPUs to use (0 uses the Go default)")
+
+	flag.StringVar(&outputPath, "output", "", "the path of a file to append uploaded slab IDs to")
+	flag.StringVar(&dbPath, "db.sqlite", "", "the path of a SQLite database to record every upload in, created if it doesn't exist")
+	flag.BoolVar(&eventsStdout, "events.stdout", false, "write a JSON line for every upload to stdout, moving logs to stderr")
+	flag.StringVar(&recordsPath, "records.csv", "", "the path of a CSV file to append a record of every upload to")
+	flag.StringVar(&deadLetterPath, "deadletter.file", "", "the path of a file to append a JSON line to for every upload that fails after exhausting -upload.retries")
+	flag.BoolVar(&outputHash, "output.hash", false, "include the SHA-256 of the uploaded data in the output file")
+
+	flag.IntVar(&httpMaxConnsPerHost, "http.max-conns-per-host", 0, "the maximum number of connections per indexer host (0 is unlimited)")
+	flag.DurationVar(&httpIdleTimeout, "http.idle-timeout", 90*time.Second, "how long idle indexer connections are kept open")
+	flag.DurationVar(&httpResponseHeaderTimeout, "http.response-header-timeout", 0, "how long to wait for the response headers of an indexer API request after it has been sent (0 waits forever); sector uploads to hosts do not use this client, use -upload.timeout to limit them")
+	flag.DurationVar(&httpKeepAlive, "http.keepalive", 30*time.Second, "the TCP keep-alive probe interval for indexer connections, negative to disable probes")
+	flag.BoolVar(&httpDisableKeepAlives, "http.disable-keepalives", false, "use a new connection for every indexer request instead of reusing idle connections")
+	flag.BoolVar(&httpForceHTTP2, "http.force-h2", false, "only use HTTP/2 for indexer requests")
+
+	flag.Float64Var(&entropy, "entropy", 1, "the fraction of generated upload data that is random, from 0 (a repeated pattern) to 1 (fully random)")
+	flag.StringVar(&randSource, "rand.source", "frand", "the random data generator to use (frand, crypto)")
+	flag.StringVar(&seed, "seed", "", "a seed used to deterministically generate the content of each upload from its index")
+	flag.IntVar(&pregenCount, "pregen.count", 0, "the number of slab-sized files to pre-generate on disk and cycle through for uploads (0 generates data while uploading)")
+	flag.StringVar(&sourcePath, "source", "", "the path of a file to upload in slab-sized chunks instead of random data (- for stdin)")
+
+	flag.StringVar(&statsStatePath, "stats.state", "", "the path of a file used to persist lifetime upload totals across restarts")
+	flag.DurationVar(&statsStateInterval, "stats.state.interval", time.Minute, "how often to save the stats state")
+	flag.DurationVar(&statsStateMaxAge, "stats.state.max-age", 10*time.Minute, "the maximum age of a saved rolling window to restore, older windows are discarded")
+
+	flag.DurationVar(&timelineWidth, "timeline.bucket", time.Minute, "the width of each bucket in the throughput timeline")
+	flag.StringVar(&timelineCSVPath, "timeline.csv", "", "the path to write the throughput timeline to as CSV")
+
+	flag.StringVar(&heatmapCSVPath, "heatmap.csv", "", "the path to write a latency-over-time heatmap grid to as CSV")
+	flag.DurationVar(&heatmapWidth, "heatmap.time-bucket", time.Minute, "the width of each time bucket in the heatmap")
+	flag.DurationVar(&heatmapLatencyWidth, "heatmap.latency-bucket", time.Second, "the width of each latency bucket in the heatmap")
+	flag.IntVar(&heatmapLatencyBuckets, "heatmap.latency-buckets", 30, "the number of latency buckets in the heatmap, the last counts all slower uploads")
+	flag.BoolVar(&noSummary, "no-summary", false, "don't print the human-readable summary table to stderr at shutdown")
+	flag.StringVar(&reportPath, "report.json", "", "the path to write a JSON report of the run to on exit, including fatal errors")
+	flag.StringVar(&summaryCSVPath, "summary.csv", "", "the path of a CSV file to append a one-row summary of the run to")
+
+	flag.Int64Var(&sizeFloor, "size.floor", proto.SectorSize, "the minimum size of uploads sampled from -size.dist; smaller sizes are clamped up to it, 0 to disable")
+	flag.StringVar(&replayPath, "replay", "", "the path of a -records.csv file to replay the upload sizes and start times of")
+	flag.DurationVar(&replayMaxGap, "replay.max-gap", time.Minute, "the longest gap between replayed uploads; longer recorded gaps are shortened (0 to keep all gaps)")
+	flag.StringVar(&sizeDistPath, "size.dist", "", "the path of a file of object sizes, one per line with an optional weight, to sample upload sizes from")
+	flag.IntVar(&shards.Data, "shards.data", shards.Data, "the number of data shards per slab")
+	flag.IntVar(&shards.Parity, "shards.parity", shards.Parity, "the number of parity shards per slab")
+	flag.Float64Var(&redundancy, "redundancy", 0, "the target redundancy ratio, used to compute the parity shards from -shards.data instead of -shards.parity")
+	flag.BoolVar(&shardsRandom, "shards.random", false, "pick random data and parity shard counts for each upload from -shards.random.data and -shards.random.parity")
+	flag.Var(&shardsRandomData, "shards.random.data", "the range of data shards to pick from with -shards.random, as min:max")
+	flag.Var(&shardsRandomParity, "shards.random.parity", "the range of parity shards to pick from with -shards.random, as min:max")
+	flag.BoolVar(&noRedundancy, "no-redundancy", false, "upload data shards only, without parity, to measure a non-durable baseline")
+	flag.IntVar(&batchSize, "batch", 1, "the number of objects each thread uploads per iteration")
+	flag.Float64Var(&uploadRate, "rate", 0, "the maximum number of uploads started per second across all threads (0 is unthrottled)")
+	flag.Float64Var(&threadRate, "throttle.per-thread", 0, "the maximum number of uploads started per second by each thread, unlike -rate which is shared by all threads (0 is unthrottled)")
+	flag.DurationVar(&sessionCheckInterval, "session.check-interval", 0, "how often to check that each app's session is still approved, skipping apps whose approval was revoked until they are approved again (0 to disable)")
+	flag.Int64Var(&uploadCount, "count", 0, "the number of objects to upload before stopping (0 is unlimited)")
+	flag.Int64Var(&limitBytes, "limit.bytes", 0, "the number of data bytes to upload before stopping (0 is unlimited)")
+	flag.StringVar(&shutdownMode, "shutdown.mode", shutdownDrain, "what to do with uploads on the first signal (drain, drain-inflight-drop-queued, cancel)")
+	flag.Func("affinity", "experimental and advisory: pin each upload thread's OS thread to a list of CPUs, e.g. 0-15,32-47; the SDK's encoding and upload goroutines are not pinned, so most CPU work is unaffected (Linux only)", parseAffinity)
+	flag.BoolVar(&failFast, "fail-fast", false, "stop the run and exit with a nonzero status on the first failed upload instead of retrying")
+	flag.IntVar(&uploadRetries, "upload.retries", 0, "the number of times to retry a failed upload before giving up on it; retries keep the size and index, and resend the same bytes only with -source, -seed, or -pregen.count since other random data is regenerated")
+	flag.IntVar(&retryBudget, "retry.budget", 0, "the maximum number of retries per minute across all threads (0 is unlimited)")
+	flag.Float64Var(&breakerThreshold, "breaker.threshold", 0, "pause all uploads when the failure ratio of recent uploads exceeds this value (0 disables)")
+	flag.DurationVar(&breakerCooldown, "breaker.cooldown", time.Minute, "how long uploads are paused before probing when the circuit breaker opens")
+	flag.IntVar(&breakerWindow, "breaker.window", 20, "the number of recent uploads used to compute the failure ratio")
+	flag.DurationVar(&uploadTimeout, "upload.timeout", 0, "the timeout for each upload, or the base timeout when -upload.timeout-per-mb is set (0 disables)")
+	flag.DurationVar(&uploadTimeoutPerMB, "upload.timeout-per-mb", 0, "additional upload timeout per MiB of object size, added to -upload.timeout")
+	flag.Float64Var(&stragglerFactor, "straggler.factor", 3, "warn when an upload takes longer than this multiple of the rolling p99 (0 disables)")
+	flag.Float64Var(&cancelFactor, "cancel.factor", 0, "cancel and restart uploads that take longer than this multiple of the rolling p99, must be greater than 1 (0 disables)")
+	flag.IntVar(&goroutineThreshold, "goroutines.threshold", 100, "warn when the goroutine count keeps growing to this many above the starting count (0 disables)")
+	flag.DurationVar(&warmup, "warmup", 0, "the duration of the warmup phase, reported separately from the measurement phase")
+
+	flag.IntVar(&autotuneMax, "autotune.max", 64, "the maximum number of threads to try when autotuning")
+	flag.DurationVar(&autotuneWindow, "autotune.window", 2*time.Minute, "how long to measure each concurrency level when autotuning")
+	flag.Float64Var(&autotuneThreshold, "autotune.threshold", 0.05, "the minimum relative throughput improvement required to try the next concurrency level")
+	flag.BoolVar(&autotuneExit, "autotune.exit", false, "exit after autotuning instead of continuing at the best concurrency level")
+
+	flag.Func("compare.a", "the first shard configuration to compare with -mode=compare, as data-of-total (e.g. 10-of-30)", parseCompareFlag(&compareA))
+	flag.Func("compare.b", "the second shard configuration to compare with -mode=compare, as data-of-total", parseCompareFlag(&compareB))
 }
 
 func main() {
-	log := newLogger()
+	// flags are parsed here rather than in init so flags registered by the
+	// init functions of other files, such as -fault.inject, are always
+	// defined first
+	flag.Parse()
 
-	sk, err := loadPrivateKey()
-	if err != nil {
-		log.Fatal("failed to load private key", zap.Error(err))
+	if runID == "" {
+		runID = newRunID()
+	}
+	fields := []zap.Field{zap.String("runID", runID), labels.field()}
+	if !logNoHost {
+		// identify the instance when logs from many hosts are aggregated
+		if hostname, err := os.Hostname(); err == nil {
+			fields = append(fields, zap.String("hostname", hostname))
+		}
+		fields = append(fields, zap.Int("pid", os.Getpid()))
 	}
+	log := newLogger().With(fields...)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer cancel()
+	if maxProcs > 0 {
+		runtime.GOMAXPROCS(maxProcs)
+	}
+	log.Info("using CPUs", zap.Int("maxprocs", runtime.GOMAXPROCS(0)), zap.Int("numCPU", runtime.NumCPU()))
 
-	resp, connected, err := sdk.Connect(ctx, indexerURL, sk, app.RegisterAppRequest{
-		Name:        "junkd Uploader",
-		Description: "A tool to upload junk data to the indexer",
-		LogoURL:     "https://example.com/logo.png",
-		ServiceURL:  "https://example.com/service",
+	var parityFlagSet bool
+	flag.Visit(func(f *flag.Flag) {
+		parityFlagSet = parityFlagSet || f.Name == "shards.parity"
 	})
-	if err != nil {
-		log.Fatal("failed to connect app", zap.Error(err))
-	} else if !connected {
-		log.Info("please approve app connection", zap.String("url", resp.ResponseURL))
-		if connected, err := resp.WaitForApproval(ctx); err != nil {
-			log.Fatal("failed to wait for app approval", zap.Error(err))
-		} else if !connected {
-			log.Fatal("user denied app connection")
+	cfg, errs := validateConfig(parityFlagSet)
+	if configCheck {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, "config error:", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
 		}
+		fmt.Fprintln(os.Stderr, "config ok")
+		return
+	} else if len(errs) > 0 {
+		fatal(log, codeInvalidConfig, "invalid configuration", errors.Join(errs...))
 	}
-	log.Info("junkd connected")
+	shards = cfg.shards
 
-	sdkClient, err := sdk.NewSDK(indexerURL, sk, sdk.WithLogger(log.Named("sdk")))
+	// deferred first so it runs after every other deferred cleanup
+	defer func() {
+		if shutdownCause() == causeFailFast {
+			os.Exit(1)
+		}
+	}()
+
+	stopProfiling, err := startProfiling(log)
 	if err != nil {
-		log.Fatal("failed to create SDK client", zap.Error(err))
-	}
-
-	var wg sync.WaitGroup
-	for n := 1; n <= threads; n++ {
-		wg.Add(1)
-		go func(log *zap.Logger) {
-			defer wg.Done()
-			log.Debug("starting upload thread")
-
-		loop:
-			for {
-				// upload slab
-				start := time.Now()
-				obj, err := sdkClient.Upload(ctx, io.LimitReader(frand.Reader, slabSize), sdk.WithRedundancy(dataShards, parityShards))
-				if err != nil {
-					log.Error("failed to upload slab, timing out for 5 minutes", zap.Error(err), zap.Duration("duration", time.Since(start)))
-					if ok := <-waitFor(ctx, 5*time.Minute); ok {
-						continue loop
-					}
-					break loop
-				} else if len(obj.Slabs) != 1 {
-					log.Error(fmt.Sprintf("expected 1 slab, got %d", len(obj.Slabs)))
-					break loop
-				}
-
-				elapsedMu.Lock()
-				elapsed = append(elapsed, time.Since(start))
-				elapsedMu.Unlock()
-
-				log.Info("upload completed", zap.Stringer("SlabID", obj.Slabs[0].ID), zap.Duration("duration", time.Since(start)), zap.String("speed", formatBpsString(redundantSlabSize, time.Since(start))))
+		fatal(log, codeOutputFailed, "failed to start profiling", err)
+	}
+	defer stopProfiling()
+	fatalHooks = append(fatalHooks, stopProfiling)
+	log.Info("using shard configuration", zap.Int("dataShards", shards.Data), zap.Int("parityShards", shards.Parity), zap.Float64("redundancy", float64(shards.Data+shards.Parity)/float64(shards.Data)))
+	if noRedundancy {
+		log.Warn("uploading without redundancy, uploaded data is not fault tolerant and results are a non-durable baseline")
+	}
+
+	if webhookURL != "" {
+		notifier = newWebhookNotifier(log.Named("webhook"), webhookURL)
+	}
+
+	if mode == "selftest" {
+		fields := []zap.Field{zap.Int("dataShards", shards.Data), zap.Int("parityShards", shards.Parity)}
+		if err := runSelfTest(log); err != nil {
+			fatal(log, codeSelfTestFailed, "self-test failed", err)
+		}
+		log.Info("self-test passed", fields...)
+		outputs.Report(log, "report.json", writeFinalReport(nil))
+		return
+	}
+
+	var source dataSource
+	if sourcePath != "" {
+		fs, err := openFileSource(sourcePath)
+		if err != nil {
+			fatal(log, codeInvalidConfig, "failed to open data source", err)
+		}
+		defer fs.Close()
+		source = fs
+		log.Info("uploading from file", zap.String("source", sourcePath))
+	} else if seed != "" {
+		source = &seededSource{seed: seed}
+		log.Info("generating upload data from seed")
+	} else {
+		source, err = newRandomSource(randSource)
+		if err != nil {
+			fatal(log, codeInvalidConfig, "failed to create data source", err)
+		} else if randSource == "crypto" {
+			log.Info("using crypto/rand for upload data, generation will be significantly slower than frand")
+		}
+		source = withEntropy(source)
+		if pregenCount > 0 {
+			log.Info("pre-generating upload data", zap.Int("files", pregenCount), zap.Int64("fileSize", shards.slabSize()))
+			ps, err := newPregenSource(source, pregenCount, shards.slabSize())
+			if err != nil {
+				fatal(log, codeInvalidConfig, "failed to pre-generate upload data", err)
 			}
-		}(log.Named(fmt.Sprintf("upload-thread-%d", n)))
+			defer ps.Close()
+			fatalHooks = append(fatalHooks, func() { ps.Close() })
+			source = ps
+			log.Info("uploading from pre-generated files", zap.String("dir", ps.dir))
+		}
+	}
+
+	if entropy < 1 {
+		log.Info("mixing a repeated pattern into upload data", zap.Float64("entropy", entropy))
+	}
+
+	if mode == "encode-bench" {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		log.Info("benchmarking erasure coding", zap.Stringer("redundancy", shards), zap.Int("threads", threads), zap.Duration("duration", encodeDuration))
+		if err := runEncodeBench(ctx, log, source); err != nil {
+			fatal(log, codeEncodeFailed, "encode benchmark failed", err)
+		}
+		outputs.Report(log, "report.json", writeFinalReport(nil))
+		return
+	}
+
+	secretSource := "flag"
+	if secretPath != "" {
+		sf := cfg.secret
+		if sf.AppSecret != "" {
+			appSecret = sf.AppSecret
+			secretSource = "secret file"
+		}
+		if sf.IndexerURL != "" {
+			indexerURL = sf.IndexerURL
+			log.Info("using indexer URL from secret file", zap.String("url", indexerURL))
+		}
+	}
+	if secretStdin {
+		secret, err := readSecretLine(os.Stdin)
+		if err != nil {
+			fatal(log, codeBadSecret, "failed to read app secret from stdin", err)
+		}
+		appSecret = secret
+		secretSource = "stdin"
+	}
+	log.Info("loading app secret", zap.String("source", secretSource))
+
+	if u, err := normalizeURL(indexerURL); err != nil {
+		fatal(log, codeInvalidConfig, "invalid indexer URL", err)
+	} else if u != indexerURL {
+		log.Debug("normalized indexer URL", zap.String("url", u))
+		indexerURL = u
+	}
+
+	keys, err := loadPrivateKeys(appCount)
+	if err != nil {
+		fatal(log, codeBadSecret, "failed to load private key", err)
+	}
+
+	transport := installTracingTransport(log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := newSignalHandler(log, cancel)
+
+	apps := make([]*appClient, len(keys))
+	for i, sk := range keys {
+		apps[i] = connectApp(ctx, log, i, sk)
+	}
+	if sessionCheckInterval > 0 {
+		go checkSessions(ctx, log.Named("session"), apps, sessionCheckInterval)
+	}
+
+	var ids *idWriter
+	if outputPath != "" {
+		ids, err = openIDWriter(outputPath)
+		if err != nil {
+			fatal(log, codeOutputFailed, "failed to open output file", err)
+		}
+		defer ids.Close()
+	}
+
+	var records *recordsWriter
+	if recordsPath != "" {
+		records, err = openRecordsWriter(recordsPath)
+		if err != nil {
+			fatal(log, codeOutputFailed, "failed to open records file", err)
+		}
+		defer records.Close()
+	}
+
+	var deadLetters *deadLetterWriter
+	if deadLetterPath != "" {
+		deadLetters, err = openDeadLetterWriter(deadLetterPath)
+		if err != nil {
+			fatal(log, codeOutputFailed, "failed to open dead letter file", err)
+		}
+		defer deadLetters.Close()
+	}
+
+	var dedup *dedupProbe
+	if mode == "dedup" {
+		dedup = newDedupProbe(source, shards.slabSize())
+		source = dedup
+		log.Info("alternating uploads between repeated and random content")
+	}
+
+	var db *sqliteRecorder
+	if dbPath != "" {
+		db, err = openSQLiteRecorder(log.Named("sqlite"), dbPath)
+		if err != nil {
+			fatal(log, codeOutputFailed, "failed to open database", err)
+		}
+		defer db.Close()
+		fatalHooks = append(fatalHooks, func() { db.Close() })
+		log.Info("recording uploads in database", zap.String("path", dbPath))
+	}
+
+	if batchSize > 1 {
+		log.Info("SDK does not support batch uploads, uploading batch objects sequentially", zap.Int("batch", batchSize))
+	}
+
+	stats := newUploadStats(warmup, timelineWidth)
+	reportStats = stats
+	if heatmapCSVPath != "" {
+		stats.heatmap = newLatencyHeatmap(stats.timeline.start, heatmapWidth, heatmapLatencyWidth, heatmapLatencyBuckets)
+	}
+	if statsStatePath != "" {
+		restoreStatsState(log, statsStatePath, statsStateMaxAge, stats)
+		go persistStatsState(ctx, log, statsStatePath, statsStateInterval, stats)
+	}
+	if warmup > 0 {
+		log.Info("warming up", zap.Duration("warmup", warmup))
+	}
+
+	u := &uploader{
+		apps:    apps,
+		source:  source,
+		ids:     ids,
+		records: records,
+		stats:   stats,
+
+		deadLetters: deadLetters,
+		db:          db,
+		dedup:       dedup,
+	}
+	if failFast {
+		u.failFast = cancel
+	}
+	if uploadCount > 0 || limitBytes > 0 {
+		u.limits = &uploadLimits{count: uploadCount, bytes: limitBytes}
+		log.Info("limiting run", zap.Int64("count", uploadCount), zap.Int64("bytes", limitBytes))
+	}
+	if eventsStdout {
+		u.events = newEventWriter(os.Stdout)
+	}
+
+	if sizeDistPath != "" {
+		u.sizes = cfg.sizes
+		u.sizes.floor = sizeFloor
+		log.Info("sampling upload sizes", zap.String("path", sizeDistPath), zap.Int("sizes", len(u.sizes.sizes)), zap.Int64("floor", sizeFloor))
+	}
+
+	if replayPath != "" {
+		u.replay = cfg.replay
+		log.Info("replaying recorded uploads", zap.String("path", replayPath), zap.Int("uploads", len(u.replay.uploads)), zap.Duration("span", u.replay.uploads[len(u.replay.uploads)-1].Offset), zap.Int("cappedGaps", u.replay.capped))
+	}
+
+	if mode == "compare" {
+		u.compare = newShardComparison(*compareA, *compareB)
+		log.Info("comparing shard configurations", zap.Stringer("a", compareA), zap.Stringer("b", compareB))
+	}
+
+	if shardsRandom {
+		rs, err := newRandomShards(shardsRandomData, shardsRandomParity)
+		if err != nil {
+			fatal(log, codeInvalidConfig, "invalid random shard ranges", err)
+		}
+		u.randomShards = rs
+		log.Info("randomizing shard configuration per upload", zap.Stringer("data", &shardsRandomData), zap.Stringer("parity", &shardsRandomParity))
 	}
-	go printUploadSpeeds(ctx, log)
-	wg.Wait()
 
-	log.Info("all upload threads finished, exiting")
+	if uploadRate > 0 {
+		u.limiter = rate.NewLimiter(rate.Limit(uploadRate), 1)
+		log.Info("limiting upload rate", zap.Float64("rate", uploadRate))
+	}
+	if len(affinityCPUs) > 0 {
+		log.Info("pinning upload threads to CPUs", zap.Ints("cpus", affinityCPUs))
+	}
+	if threadRate > 0 {
+		log.Info("limiting per-thread upload rate", zap.Float64("rate", threadRate))
+	}
+
+	if retryBudget > 0 {
+		u.retryBudget = rate.NewLimiter(rate.Limit(float64(retryBudget)/60), retryBudget)
+	}
+
+	if breakerThreshold > 0 {
+		u.breaker = newCircuitBreaker(log.Named("breaker"), breakerThreshold, breakerCooldown, breakerWindow)
+	}
+
+	if statsdAddr != "" {
+		sc, err := newStatsdClient(log.Named("statsd"), statsdAddr)
+		if err != nil {
+			fatal(log, codeInvalidConfig, "failed to create statsd client", err)
+		}
+		u.metrics = sc
+		log.Info("sending metrics to statsd", zap.String("addr", statsdAddr))
+	}
+
+	pool := newWorkerPool(ctx, log, u.worker)
+	signals.SetPool(pool)
+	if u.metrics != nil {
+		go u.metrics.sendGauges(ctx, pool, stats)
+	}
+	go printUploadSpeeds(ctx, log, stats, transport, u.limits)
+	if adminAddr != "" {
+		if err := serveAdmin(ctx, log.Named("admin"), adminAddr, pool); err != nil {
+			fatal(log, codeInvalidConfig, "failed to start admin API", err)
+		}
+	}
+
+	if notifier != nil {
+		notifier.Notify(newWebhookEvent("start", stats))
+	}
+
+	var chaosTimeline []threadChange
+	switch {
+	case chaosRange.Max > 0:
+		log.Info("randomizing thread count", zap.Stringer("threads", &chaosRange), zap.Duration("interval", chaosInterval))
+		chaosTimeline = chaosThreads(pool.Context(), log, pool, chaosRange, chaosInterval)
+	case mode == "upload", mode == "compare", mode == "dedup":
+		pool.Resize(threads)
+	case mode == "autotune":
+		log.Info("autotuning concurrency", zap.Int("max", autotuneMax), zap.Duration("window", autotuneWindow), zap.Float64("threshold", autotuneThreshold))
+		best, sweep := autotune(pool.Context(), log, pool, stats)
+		if len(sweep) > 0 {
+			printAutotuneSweep(best, sweep)
+			log.Info("autotune complete", zap.Int("threads", best.Threads), zap.String("throughput", formatBpsString(best.Bytes, best.Window)))
+		}
+		if autotuneExit {
+			setShutdownCause(causeAutotuneComplete)
+			pool.Resize(0)
+		}
+	}
+	pool.Wait()
+
+	logShutdown(log, stats)
+	if shutdownCause() == causeSignal {
+		u.drain.log(log)
+	}
+	logThreadTimeline(log, chaosTimeline, time.Now())
+	if notifier != nil {
+		notifier.Close(newWebhookEvent("end", stats))
+	}
+	if u.metrics != nil {
+		u.metrics.Close()
+	}
+	logSummary(log, stats)
+	transport.logReuse(log)
+	if len(apps) > 1 {
+		logAppSummary(log, apps, stats.elapsed())
+	}
+	if cancelFactor > 0 {
+		log.Info("latency budget cancellations", zap.Int64("canceled", u.cancellations.Load()), zap.Float64("factor", cancelFactor))
+	}
+	if u.randomShards != nil {
+		u.randomShards.logSummary(log)
+	}
+	if u.compare != nil {
+		u.compare.printSummary(humanOutput())
+	}
+	if u.dedup != nil {
+		u.dedup.printSummary(humanOutput())
+		u.dedup.logFindings(log)
+	}
+	if u.replay != nil {
+		u.replay.logSummary(log)
+	}
+	if u.sizes != nil {
+		n, clamped, minSize, mean, maxSize := u.sizes.sampled()
+		log.Info("sampled size distribution", zap.Int("samples", n), zap.Int("clamped", clamped), zap.Int64("floor", sizeFloor), zap.Int64("min", minSize), zap.Int64("mean", mean), zap.Int64("max", maxSize))
+	}
+	buckets := stats.timelineBuckets()
+	logTimeline(log, timelineWidth, buckets)
+	if timelineCSVPath != "" {
+		outputs.Report(log, "timeline.csv", writeTimelineCSV(timelineCSVPath, stats.timeline.start, timelineWidth, buckets))
+	}
+	if heatmapCSVPath != "" {
+		outputs.Report(log, "heatmap.csv", stats.writeHeatmapCSV(heatmapCSVPath))
+	}
+	if summaryCSVPath != "" {
+		outputs.Report(log, "summary.csv", appendSummaryCSV(summaryCSVPath, newRunSummary(stats)))
+	}
+	if statsStatePath != "" {
+		outputs.Report(log, "stats.state", saveStatsState(statsStatePath, newStatsState(stats)))
+	}
+	outputs.Report(log, "report.json", writeFinalReport(nil))
+	outputs.LogSummary(log)
+	if !noSummary {
+		successes, failures := stats.outcomes()
+		rs := newRunSummary(stats)
+		rs.ReusedConns, rs.NewConns = transport.connections()
+		printSummaryTable(os.Stderr, rs, successes, failures)
+	}
 }
 
-func waitFor(ctx context.Context, d time.Duration) <-chan bool {
-	c := make(chan bool, 1)
-	go func() {
-		select {
-		case <-ctx.Done():
-			c <- false
-		case <-time.After(d):
-			c <- true
+// parseCompareFlag returns a flag.Func parser for a -compare.* shard
+// configuration.
+func parseCompareFlag(sc **shardConfig) func(string) error {
+	return func(s string) error {
+		v, err := parseShardConfig(s)
+		if err != nil {
+			return err
 		}
-	}()
-	return c
+		*sc = &v
+		return nil
+	}
+}
+
+// newRunID returns a random version 4 UUID.
+func newRunID() string {
+	b := frand.Bytes(16)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
 
-func loadPrivateKey() (types.PrivateKey, error) {
+// waitFor blocks for the duration d, returning false if the context is
+// canceled first.
+func waitFor(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// normalizeURL validates the indexer URL, adding a missing http:// scheme and
+// removing trailing slashes.
+func normalizeURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", errors.New("indexer URL is required")
+	} else if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid indexer URL %q: %w", s, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid indexer URL %q: unsupported scheme %q", s, u.Scheme)
+	} else if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid indexer URL %q: missing host", s)
+	} else if strings.Contains(u.Hostname(), " ") {
+		return "", fmt.Errorf("invalid indexer URL %q: invalid host %q", s, u.Hostname())
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return "", fmt.Errorf("invalid indexer URL %q: invalid port %q", s, port)
+		}
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	return u.String(), nil
+}
+
+// loadPrivateKeys derives n app keys from the app secret at indices 0
+// through n-1.
+func loadPrivateKeys(n int) ([]types.PrivateKey, error) {
 	if appSecret == "" {
-		return types.PrivateKey{}, fmt.Errorf("app secret is required")
+		return nil, fmt.Errorf("app secret is required")
 	}
 
 	derived, err := pbkdf2.Key(sha256.New, appSecret, []byte("junkd-pk-salt"), 4096, 32)
 	if err != nil {
-		return types.PrivateKey{}, fmt.Errorf("failed to derive key: %w", err)
+		return nil, fmt.Errorf("failed to derive key: %w", err)
 	}
 
 	var seed [32]byte
 	copy(seed[:], derived)
-	return wallet.KeyFromSeed(&seed, 0), nil
+	keys := make([]types.PrivateKey, n)
+	for i := range keys {
+		keys[i] = wallet.KeyFromSeed(&seed, uint64(i))
+	}
+	return keys, nil
 }
 
 func newLogger() *zap.Logger {
@@ -160,7 +761,39 @@ func newLogger() *zap.Logger {
 	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
 	cfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	cfg.EncodeDuration = zapcore.MillisDurationEncoder
-	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(cfg), zapcore.Lock(os.Stdout), logLevel))
+	enc := zapcore.NewConsoleEncoder(cfg)
+	ws := zapcore.Lock(humanOutput())
+	if logSampleInitial <= 0 {
+		return zap.New(zapcore.NewCore(enc, ws, logLevel), zap.WithFatalHook(fatalExit{}))
+	}
+
+	// only sample entries below warn level so errors are always logged
+	sampled := zapcore.NewCore(enc, ws, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return logLevel.Enabled(l) && l < zapcore.WarnLevel
+	}))
+	unsampled := zapcore.NewCore(enc, ws, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return logLevel.Enabled(l) && l >= zapcore.WarnLevel
+	}))
+	return zap.New(zapcore.NewTee(zapcore.NewSamplerWithOptions(sampled, time.Second, logSampleInitial, logSampleThereafter), unsampled), zap.WithFatalHook(fatalExit{}))
+}
+
+// parseLogSample parses a log sampling configuration in the form
+// "initial:thereafter".
+func parseLogSample(s string) error {
+	initial, thereafter, ok := strings.Cut(s, ":")
+	if !ok {
+		return errors.New("expected initial:thereafter")
+	}
+	i, err := strconv.Atoi(initial)
+	if err != nil || i < 1 {
+		return fmt.Errorf("initial must be a positive integer, got %q", initial)
+	}
+	t, err := strconv.Atoi(thereafter)
+	if err != nil || t < 0 {
+		return fmt.Errorf("thereafter must be a non-negative integer, got %q", thereafter)
+	}
+	logSampleInitial, logSampleThereafter = i, t
+	return nil
 }
 
 func formatBpsString(b int64, t time.Duration) string {
@@ -187,32 +820,110 @@ func formatBpsString(b int64, t time.Duration) string {
 	return fmt.Sprintf("%.2f %cbps", speed, units[i])
 }
 
-func printUploadSpeeds(ctx context.Context, log *zap.Logger) {
+func printUploadSpeeds(ctx context.Context, log *zap.Logger, stats *uploadStats, transport *tracingTransport, limits *uploadLimits) {
 	t := time.NewTicker(2 * time.Minute)
 	defer t.Stop()
 
+	gm := goroutineMonitor{threshold: goroutineThreshold}
+	lastSuccesses, lastFailures := stats.outcomes()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-t.C:
-			elapsedMu.Lock()
-			if len(elapsed) > 1000 {
-				elapsed = elapsed[len(elapsed)-1000:]
+			// uploads vary in size with -size.dist, -replay, and random
+			// or compared shard configurations, so the speed is computed
+			// from the bytes actually uploaded
+			speed := "unknown"
+			if redundant, d, ok := stats.windowSpeed(); ok {
+				speed = formatBpsString(redundant, d)
+			}
+			fields := []zap.Field{zap.String("averageSpeed", speed)}
+			if ops, ok := stats.windowOpsPerSecond(); ok {
+				fields = append(fields, zap.Float64("opsPerSecond", ops))
+			}
+			successes, failures := stats.outcomes()
+			interval := maps.Clone(failures)
+			for errType, n := range lastFailures {
+				interval[errType] -= n
 			}
-			times := elapsed
-			elapsedMu.Unlock()
-
-			var avg time.Duration
-			if len(times) == 0 {
-				avg = time.Second
-			} else {
-				for _, t := range times {
-					avg += t
-				}
-				avg /= time.Duration(len(times))
+			if rate, ok := successRate(successes-lastSuccesses, interval); ok {
+				fields = append(fields, zap.Float64("successRate", rate))
 			}
-			log.Info("average upload time", zap.String("averageSpeed", formatBpsString(int64(redundantSlabSize), avg)))
+			lastSuccesses, lastFailures = successes, failures
+			if limits != nil {
+				fields = append(fields, zap.String("eta", limits.etaString(stats)))
+			}
+			log.Info("average upload time", fields...)
+			transport.logTimings(log)
+			transport.logReuse(log)
+			gm.check(log)
 		}
 	}
 }
+
+// goroutineGrowthTicks is the number of consecutive increases in the
+// goroutine count before a possible leak is reported.
+const goroutineGrowthTicks = 3
+
+// A goroutineMonitor watches for sustained growth in the number of
+// goroutines relative to the count at the first check.
+type goroutineMonitor struct {
+	baseline  int
+	threshold int
+
+	last   int
+	streak int
+}
+
+func (gm *goroutineMonitor) check(log *zap.Logger) {
+	n := runtime.NumGoroutine()
+	if gm.baseline == 0 {
+		// use the first tick as the baseline so the upload threads are
+		// already running
+		gm.baseline = n
+	}
+	log.Debug("goroutine count", zap.Int("goroutines", n), zap.Int("baseline", gm.baseline))
+
+	if n > gm.last {
+		gm.streak++
+	} else {
+		gm.streak = 0
+	}
+	gm.last = n
+
+	if gm.threshold > 0 && gm.streak >= goroutineGrowthTicks && n-gm.baseline > gm.threshold {
+		log.Warn("goroutine count is growing, possible leak", zap.Int("goroutines", n), zap.Int("baseline", gm.baseline), zap.Int("consecutiveIncreases", gm.streak))
+	}
+}
+
+func logSummary(log *zap.Logger, stats *uploadStats) {
+	warmupStats, measureStats := stats.phases()
+	if warmup > 0 {
+		log.Info("warmup phase summary", zap.Int("uploads", warmupStats.Uploads), zap.Duration("meanDuration", warmupStats.meanDuration()), zap.String("averageSpeed", warmupStats.speed()))
+	}
+	if perMB, overhead, r, ok := stats.sizeLatency(); ok {
+		log.Info("latency vs size", zap.Duration("perMB", perMB), zap.Duration("overhead", overhead), zap.Float64("correlation", r))
+	}
+	if batches, mean := stats.batchMean(); batches > 0 {
+		log.Info("batch summary", zap.Int("batches", batches), zap.Int("batchSize", batchSize), zap.Duration("meanBatchDuration", mean))
+	}
+	var opsPerSecond float64
+	if d := stats.elapsed() - warmup; d > 0 {
+		opsPerSecond = float64(measureStats.Uploads) / d.Seconds()
+	}
+	log.Info("measurement phase summary", zap.Stringer("redundancy", shards), zap.Bool("durable", shards.durable()), zap.Int("uploads", measureStats.Uploads), zap.Duration("meanDuration", measureStats.meanDuration()), zap.String("averageSpeed", measureStats.speed()), zap.Float64("opsPerSecond", opsPerSecond))
+	if uploadRate > 0 {
+		achieved := float64(warmupStats.Uploads+measureStats.Uploads) / stats.elapsed().Seconds()
+		log.Info("upload rate summary", zap.Float64("targetRate", uploadRate), zap.Float64("achievedRate", achieved))
+	}
+	if successes, failures := stats.outcomes(); len(failures) > 0 {
+		rate, _ := successRate(successes, failures)
+		log.Info("reliability summary", zap.Int("successes", successes), zap.Any("failures", failures), zap.Float64("successRate", rate))
+	}
+	if statsStatePath != "" {
+		lifetime := stats.lifetimeStats()
+		log.Info("lifetime summary", zap.Int("uploads", lifetime.Uploads), zap.Int64("bytes", lifetime.Bytes), zap.Duration("meanDuration", lifetime.meanDuration()))
+	}
+}
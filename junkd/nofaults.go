@@ -0,0 +1,14 @@
+//go:build !faults
+
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// injectFault returns r unchanged. Build with the faults tag to enable
+// -fault.inject.
+func injectFault(_ context.Context, r io.Reader) io.Reader {
+	return r
+}
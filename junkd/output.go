@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// An idWriter records uploaded slab IDs to a tab-separated file so they can
+// be referenced by later runs. Each line contains the comma-separated slab
+// IDs of the object, the size of the uploaded data, the index of the chunk
+// within the data source, and optionally the SHA-256 of the data.
+type idWriter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (w *idWriter) WriteUpload(slabIDs []string, size int64, index uint64, h hash.Hash) error {
+	line := fmt.Sprintf("%s\t%d\t%d", strings.Join(slabIDs, ","), size, index)
+	if h != nil {
+		line += "\t" + hex.EncodeToString(h.Sum(nil))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := fmt.Fprintln(w.f, line); err != nil {
+		return fmt.Errorf("failed to write upload record: %w", err)
+	}
+	return nil
+}
+
+func (w *idWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+func openIDWriter(path string) (*idWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	return &idWriter{f: f}, nil
+}
+
+// outputFailure is the failure state of a single auxiliary output.
+type outputFailure struct {
+	failures int
+	lastErr  error
+	failing  bool
+}
+
+// An outputHealth tracks write failures of auxiliary outputs, such as the
+// stats state and upload records, so a broken output is logged once instead
+// of on every write and never interrupts uploads.
+type outputHealth struct {
+	mu      sync.Mutex
+	outputs map[string]*outputFailure
+}
+
+// Report records the result of a write to the named output. The first
+// failure is logged as an error, subsequent failures at debug level until the
+// output recovers.
+func (oh *outputHealth) Report(log *zap.Logger, name string, err error) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+
+	if oh.outputs == nil {
+		oh.outputs = make(map[string]*outputFailure)
+	}
+	of := oh.outputs[name]
+	if err == nil {
+		if of != nil && of.failing {
+			of.failing = false
+			log.Info("output recovered", zap.String("output", name))
+		}
+		return
+	}
+
+	if of == nil {
+		of = new(outputFailure)
+		oh.outputs[name] = of
+	}
+	of.failures++
+	of.lastErr = err
+	if !of.failing {
+		of.failing = true
+		log.Error("failed to write output, continuing without it until it recovers", zap.String("output", name), zap.Error(err))
+	} else {
+		log.Debug("failed to write output", zap.String("output", name), zap.Error(err))
+	}
+}
+
+// LogSummary logs every output that failed during the run.
+func (oh *outputHealth) LogSummary(log *zap.Logger) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+
+	for name, of := range oh.outputs {
+		log.Warn("output had write failures", zap.String("output", name), zap.Int("failures", of.failures), zap.Bool("failing", of.failing), zap.Error(of.lastErr))
+	}
+}
+
+// outputs tracks the health of all auxiliary outputs.
+var outputs outputHealth
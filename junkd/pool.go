@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// A workerFunc runs until stop is canceled. In-flight work should only be
+// interrupted when ctx is canceled.
+type workerFunc func(ctx, stop context.Context, log *zap.Logger)
+
+type poolWorker struct {
+	id   int
+	stop context.CancelFunc
+}
+
+// A workerPool runs a variable number of workers.
+type workerPool struct {
+	ctx context.Context
+	log *zap.Logger
+	fn  workerFunc
+
+	wg sync.WaitGroup
+
+	// drainCtx is canceled when the pool starts draining
+	drainCtx    context.Context
+	drainCancel context.CancelFunc
+
+	mu       sync.Mutex
+	nextID   int
+	workers  []poolWorker
+	draining bool
+}
+
+func (p *workerPool) remove(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, w := range p.workers {
+		if w.id == id {
+			p.workers = append(p.workers[:i], p.workers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Size returns the number of active workers.
+func (p *workerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.workers)
+}
+
+// Resize starts or stops workers until n are active. Stopped workers finish
+// their current work before exiting. No workers are started once the pool is
+// draining.
+func (p *workerPool) Resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.workers) < n && !p.draining {
+		p.nextID++
+		id := p.nextID
+		stop, cancel := context.WithCancel(p.ctx)
+		p.workers = append(p.workers, poolWorker{id: id, stop: cancel})
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.remove(id)
+			defer cancel()
+			p.fn(p.ctx, stop, p.log.Named(fmt.Sprintf("upload-thread-%d", id)))
+		}()
+	}
+	for len(p.workers) > n {
+		w := p.workers[len(p.workers)-1]
+		p.workers = p.workers[:len(p.workers)-1]
+		w.stop()
+	}
+}
+
+// Drain stops all workers, letting them finish their current work, and
+// prevents new workers from being started.
+func (p *workerPool) Drain() {
+	p.mu.Lock()
+	p.draining = true
+	p.mu.Unlock()
+	p.drainCancel()
+	p.Resize(0)
+}
+
+// Context returns a context that is canceled when the pool starts draining.
+func (p *workerPool) Context() context.Context {
+	return p.drainCtx
+}
+
+// Wait blocks until all workers have exited.
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
+
+func newWorkerPool(ctx context.Context, log *zap.Logger, fn workerFunc) *workerPool {
+	drainCtx, drainCancel := context.WithCancel(ctx)
+	return &workerPool{
+		ctx: ctx,
+		log: log,
+		fn:  fn,
+
+		drainCtx:    drainCtx,
+		drainCancel: drainCancel,
+	}
+}
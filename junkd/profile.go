@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// startProfiling starts writing a CPU profile to -pprof.cpu and returns a
+// function that stops it and writes a heap profile to -pprof.mem. The
+// returned function is safe to call more than once.
+func startProfiling(log *zap.Logger) (func(), error) {
+	var cpu *os.File
+	if cpuProfilePath != "" {
+		f, err := os.Create(cpuProfilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		} else if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		cpu = f
+		log.Info("writing CPU profile", zap.String("path", cpuProfilePath))
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if cpu != nil {
+				pprof.StopCPUProfile()
+				outputs.Report(log, "pprof.cpu", cpu.Close())
+			}
+			if memProfilePath != "" {
+				outputs.Report(log, "pprof.mem", writeHeapProfile(memProfilePath))
+			}
+		})
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create heap profile: %w", err)
+	}
+	defer f.Close()
+
+	// collect garbage so the profile reflects live objects
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return fmt.Errorf("failed to write heap profile: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An uploadRecord describes a single completed upload.
+type uploadRecord struct {
+	Time          time.Time
+	Thread        string
+	Size          int64
+	RedundantSize int64
+	Duration      time.Duration
+	SlabID        string
+}
+
+var recordsCSVHeader = []string{"time", "thread", "size", "redundantSize", "durationMs", "slabID"}
+
+// A recordsWriter appends a CSV row for every completed upload.
+type recordsWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+func (rw *recordsWriter) Write(r uploadRecord) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.w.Write([]string{
+		r.Time.Format(time.RFC3339Nano),
+		r.Thread,
+		strconv.FormatInt(r.Size, 10),
+		strconv.FormatInt(r.RedundantSize, 10),
+		strconv.FormatFloat(float64(r.Duration)/float64(time.Millisecond), 'f', 3, 64),
+		r.SlabID,
+	})
+	rw.w.Flush()
+	if err := rw.w.Error(); err != nil {
+		return fmt.Errorf("failed to write upload record: %w", err)
+	}
+	return nil
+}
+
+func (rw *recordsWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.f.Close()
+}
+
+func openRecordsWriter(path string) (*recordsWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open records file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat records file: %w", err)
+	}
+
+	rw := &recordsWriter{f: f, w: csv.NewWriter(f)}
+	if info.Size() == 0 {
+		rw.w.Write(recordsCSVHeader)
+		rw.w.Flush()
+		if err := rw.w.Error(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write records header: %w", err)
+		}
+	}
+	return rw, nil
+}
+
+// A sizeLatencyFit is a running least-squares fit of upload duration against
+// upload size.
+type sizeLatencyFit struct {
+	n                   float64
+	sumX, sumY          float64
+	sumXY, sumX2, sumY2 float64
+}
+
+// add adds a sample with the size in MB and the duration in seconds.
+func (f *sizeLatencyFit) add(size int64, d time.Duration) {
+	x, y := float64(size)/1e6, d.Seconds()
+	f.n++
+	f.sumX += x
+	f.sumY += y
+	f.sumXY += x * y
+	f.sumX2 += x * x
+	f.sumY2 += y * y
+}
+
+// result returns the time per MB, the fixed per-upload overhead, and the
+// Pearson correlation coefficient. ok is false if the sizes do not vary.
+func (f *sizeLatencyFit) result() (perMB, overhead time.Duration, r float64, ok bool) {
+	varX := f.n*f.sumX2 - f.sumX*f.sumX
+	if f.n < 2 || varX <= 0 {
+		return 0, 0, 0, false
+	}
+	cov := f.n*f.sumXY - f.sumX*f.sumY
+	slope := cov / varX
+	intercept := (f.sumY - slope*f.sumX) / f.n
+	if varY := f.n*f.sumY2 - f.sumY*f.sumY; varY > 0 {
+		r = cov / math.Sqrt(varX*varY)
+	}
+	return time.Duration(slope * float64(time.Second)), time.Duration(intercept * float64(time.Second)), r, true
+}
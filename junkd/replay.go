@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A replayUpload is a single upload from a recorded workload.
+type replayUpload struct {
+	// Offset is when the upload started relative to the first upload.
+	Offset time.Duration
+	Size   int64
+}
+
+// A replaySchedule replays the sizes and start times of uploads recorded by
+// -records.csv. Uploads are handed out in order and each is started at its
+// recorded offset from the start of the replay, or as soon as a thread is
+// free if the replay is falling behind.
+type replaySchedule struct {
+	uploads []replayUpload
+	// capped is the number of gaps between uploads that were shortened to
+	// the maximum gap
+	capped int
+
+	mu        sync.Mutex
+	start     time.Time
+	next      int
+	late      []time.Duration
+	lastStart time.Time
+}
+
+// nextUpload returns the size of the next upload and when it should start. It
+// returns io.EOF once every upload has been replayed.
+func (rs *replaySchedule) nextUpload() (int64, time.Time, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.next >= len(rs.uploads) {
+		return 0, time.Time{}, io.EOF
+	} else if rs.start.IsZero() {
+		rs.start = time.Now()
+	}
+	ru := rs.uploads[rs.next]
+	rs.next++
+	return ru.Size, rs.start.Add(ru.Offset), nil
+}
+
+// recordStart records that an upload scheduled for scheduled started at
+// started.
+func (rs *replaySchedule) recordStart(scheduled, started time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.late = append(rs.late, max(started.Sub(scheduled), 0))
+	rs.lastStart = started
+}
+
+// logSummary logs how closely the replay matched the recorded start times.
+func (rs *replaySchedule) logSummary(log *zap.Logger) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.late) == 0 {
+		return
+	}
+
+	sorted := slices.Sorted(slices.Values(rs.late))
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	log.Info("replay timing",
+		zap.Int("replayed", len(rs.late)),
+		zap.Int("recorded", len(rs.uploads)),
+		zap.Int("cappedGaps", rs.capped),
+		zap.Duration("targetSpan", rs.uploads[len(rs.late)-1].Offset),
+		zap.Duration("actualSpan", rs.lastStart.Sub(rs.start)),
+		zap.Duration("meanLateness", total/time.Duration(len(sorted))),
+		zap.Duration("p50Lateness", percentile(sorted, 50)),
+		zap.Duration("p99Lateness", percentile(sorted, 99)),
+		zap.Duration("maxLateness", sorted[len(sorted)-1]))
+}
+
+// loadReplay reads the uploads recorded in a -records.csv file. Each upload
+// starts its duration before its recorded completion time. Gaps between
+// consecutive uploads longer than maxGap, such as between separate runs
+// appended to the same file, are shortened to maxGap.
+func loadReplay(path string, maxGap time.Duration) (*replaySchedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("replay file %q is empty", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read replay header: %w", err)
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[name] = i
+	}
+	for _, name := range []string{"time", "size", "durationMs"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("replay file %q has no %q column", path, name)
+		}
+	}
+
+	type started struct {
+		time time.Time
+		size int64
+	}
+	var records []started
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read replay file: %w", err)
+		}
+		end, err := time.Parse(time.RFC3339Nano, row[cols["time"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time %q", line, row[cols["time"]])
+		}
+		size, err := strconv.ParseInt(row[cols["size"]], 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("line %d: invalid size %q", line, row[cols["size"]])
+		}
+		ms, err := strconv.ParseFloat(row[cols["durationMs"]], 64)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("line %d: invalid duration %q", line, row[cols["durationMs"]])
+		}
+		records = append(records, started{time: end.Add(-time.Duration(ms * float64(time.Millisecond))), size: size})
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("replay file %q has no uploads", path)
+	}
+	slices.SortStableFunc(records, func(a, b started) int {
+		return a.time.Compare(b.time)
+	})
+
+	rs := &replaySchedule{uploads: make([]replayUpload, len(records))}
+	var offset time.Duration
+	for i, rec := range records {
+		if i > 0 {
+			gap := rec.time.Sub(records[i-1].time)
+			if maxGap > 0 && gap > maxGap {
+				gap = maxGap
+				rs.capped++
+			}
+			offset += gap
+		}
+		rs.uploads[i] = replayUpload{Offset: offset, Size: rec.size}
+	}
+	return rs, nil
+}
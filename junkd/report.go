@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runStart is when the process started, used for reports written before the
+// upload stats exist.
+var runStart = time.Now()
+
+// reportStats, if set, provides the counters included in the final report.
+// It is package-level so reports written on fatal errors include the
+// progress made so far.
+var reportStats *uploadStats
+
+// A runReport is the final result of a run written to -report.json.
+type runReport struct {
+	RunID  string    `json:"runID"`
+	Mode   string    `json:"mode"`
+	Status string    `json:"status"`
+	Cause  string    `json:"cause"`
+	Labels labelSet  `json:"labels,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+
+	// Entropy is the fraction of generated upload data that was random.
+	Entropy float64 `json:"entropy"`
+	// Affinity is the CPUs upload threads were pinned to, compared between
+	// reports to measure the effect of -affinity.
+	Affinity []int `json:"affinity,omitempty"`
+
+	Uploads        int            `json:"uploads"`
+	Bytes          int64          `json:"bytes"`
+	RedundantBytes int64          `json:"redundantBytes"`
+	Failures       map[string]int `json:"failures,omitempty"`
+	P50            time.Duration  `json:"p50"`
+	P90            time.Duration  `json:"p90"`
+	P99            time.Duration  `json:"p99"`
+
+	// Code, Message, and Error describe the failure of a fatal run.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// writeFinalReport writes the run report to -report.json. fe describes the
+// failure if the run ended with a fatal error.
+func writeFinalReport(fe *fatalError) error {
+	if reportPath == "" {
+		return nil
+	}
+
+	r := runReport{
+		RunID:    runID,
+		Mode:     mode,
+		Status:   "completed",
+		Cause:    shutdownCause(),
+		Labels:   labels,
+		Entropy:  entropy,
+		Affinity: affinityCPUs,
+		Start:    runStart,
+		End:      time.Now(),
+	}
+	if r.Cause == causeFailFast {
+		r.Status = "failed"
+	}
+	if fe != nil {
+		r.Status = "fatal"
+		r.Code, r.Message, r.Error = fe.Code, fe.Message, fe.Error
+	}
+	if reportStats != nil {
+		warmupStats, measureStats := reportStats.phases()
+		r.Start = reportStats.timeline.start
+		r.Uploads = warmupStats.Uploads + measureStats.Uploads
+		r.Bytes = warmupStats.Bytes + measureStats.Bytes
+		r.RedundantBytes = warmupStats.RedundantBytes + measureStats.RedundantBytes
+		_, r.Failures = reportStats.outcomes()
+		r.P50, _ = reportStats.windowPercentile(50)
+		r.P90, _ = reportStats.windowPercentile(90)
+		r.P99, _ = reportStats.windowPercentile(99)
+	}
+
+	buf, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	tmp := reportPath + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	} else if err := os.Rename(tmp, reportPath); err != nil {
+		return fmt.Errorf("failed to rename report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// cpuTime is not supported on this platform.
+func cpuTime() (user, sys time.Duration, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// secretFile is the contents of a mounted secret file. The file may either be
+// a JSON object or a list of KEY=VALUE lines using the APP_SECRET and
+// INDEXER_URL keys.
+type secretFile struct {
+	AppSecret  string `json:"appSecret"`
+	IndexerURL string `json:"indexerURL"`
+}
+
+func parseSecretFile(buf []byte) (sf secretFile, err error) {
+	buf = bytes.TrimSpace(buf)
+	if bytes.HasPrefix(buf, []byte("{")) {
+		if err := json.Unmarshal(buf, &sf); err != nil {
+			return secretFile{}, fmt.Errorf("failed to decode JSON: %w", err)
+		}
+		return sf, nil
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		if !ok {
+			return secretFile{}, fmt.Errorf("line %d: expected KEY=VALUE", line)
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch strings.TrimSpace(key) {
+		case "APP_SECRET":
+			sf.AppSecret = value
+		case "INDEXER_URL":
+			sf.IndexerURL = value
+		}
+	}
+	return sf, s.Err()
+}
+
+func loadSecretFile(path string) (secretFile, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return secretFile{}, fmt.Errorf("failed to read secret file: %w", err)
+	}
+	sf, err := parseSecretFile(buf)
+	if err != nil {
+		return secretFile{}, fmt.Errorf("failed to parse secret file: %w", err)
+	}
+	return sf, nil
+}
+
+// readSecretLine reads the app secret from the first line of r.
+func readSecretLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	secret := strings.TrimSpace(line)
+	if secret == "" {
+		return "", errors.New("secret is empty")
+	}
+	return secret, nil
+}
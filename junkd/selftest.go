@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/reedsolomon"
+	proto "go.sia.tech/core/rhp/v4"
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+// runSelfTest erasure codes a random slab using the configured shard counts,
+// drops as many shards as there are parity shards, and checks that the
+// original data is reconstructed.
+func runSelfTest(log *zap.Logger) error {
+	enc, err := reedsolomon.New(shards.Data, shards.Parity)
+	if err != nil {
+		return fmt.Errorf("failed to create encoder: %w", err)
+	}
+
+	sectors := make([][]byte, shards.Data+shards.Parity)
+	for i := range sectors {
+		sectors[i] = make([]byte, proto.SectorSize)
+		if i < shards.Data {
+			frand.Read(sectors[i])
+		}
+	}
+	if err := enc.Encode(sectors); err != nil {
+		return fmt.Errorf("failed to encode slab: %w", err)
+	}
+	original := make([][]byte, len(sectors))
+	for i := range sectors {
+		original[i] = bytes.Clone(sectors[i])
+	}
+
+	dropped := frand.Perm(len(sectors))[:shards.Parity]
+	for _, i := range dropped {
+		sectors[i] = nil
+	}
+	log.Debug("dropped shards", zap.Ints("shards", dropped))
+
+	if err := enc.Reconstruct(sectors); err != nil {
+		return fmt.Errorf("failed to reconstruct slab: %w", err)
+	}
+	for i := range sectors {
+		if !bytes.Equal(sectors[i], original[i]) {
+			return fmt.Errorf("reconstructed shard %d does not match the original", i)
+		}
+	}
+	if ok, err := enc.Verify(sectors); err != nil {
+		return fmt.Errorf("failed to verify slab: %w", err)
+	} else if !ok {
+		return errors.New("reconstructed slab failed verification")
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	proto "go.sia.tech/core/rhp/v4"
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+// A shardConfig is the erasure coding configuration used for an upload.
+type shardConfig struct {
+	Data   int
+	Parity int
+}
+
+func (sc shardConfig) String() string {
+	return fmt.Sprintf("%d-of-%d", sc.Data, sc.Data+sc.Parity)
+}
+
+// slabSize returns the number of bytes of data stored in a single slab.
+func (sc shardConfig) slabSize() int64 {
+	return int64(sc.Data) * proto.SectorSize
+}
+
+// redundantSlabSize returns the number of bytes uploaded to hosts for a
+// single slab.
+func (sc shardConfig) redundantSlabSize() int64 {
+	return int64(sc.Data+sc.Parity) * proto.SectorSize
+}
+
+// redundantSize returns the number of bytes uploaded to hosts for an object
+// of the given size. Slabs are always fully padded.
+func (sc shardConfig) redundantSize(size int64) int64 {
+	slabs := (size + sc.slabSize() - 1) / sc.slabSize()
+	return slabs * sc.redundantSlabSize()
+}
+
+// durable reports whether uploads can tolerate the loss of a host.
+func (sc shardConfig) durable() bool {
+	return sc.Parity > 0
+}
+
+// maxShards is the maximum total number of shards in a slab.
+const maxShards = 256
+
+// validate returns an error if the configuration cannot be used to upload.
+func (sc shardConfig) validate() error {
+	switch {
+	case sc.Data < 1:
+		return fmt.Errorf("data shards must be at least 1, got %d", sc.Data)
+	case sc.Parity < 0:
+		return fmt.Errorf("parity shards must not be negative, got %d", sc.Parity)
+	case sc.Data+sc.Parity > maxShards:
+		return fmt.Errorf("total shards must be at most %d, got %d", maxShards, sc.Data+sc.Parity)
+	}
+	return nil
+}
+
+// shardsForRedundancy returns the shard configuration with the given number
+// of data shards and the fewest parity shards that reach the target
+// redundancy.
+func shardsForRedundancy(data int, redundancy float64) (shardConfig, error) {
+	if redundancy < 1 {
+		return shardConfig{}, fmt.Errorf("redundancy must be at least 1, got %v", redundancy)
+	}
+	// subtract a small epsilon so a ratio like 1.5 on 2 data shards is not
+	// rounded up because of floating point error
+	total := int(math.Ceil(float64(data)*redundancy - 1e-9))
+	sc := shardConfig{Data: data, Parity: total - data}
+	if err := sc.validate(); err != nil {
+		return shardConfig{}, fmt.Errorf("redundancy %v is not achievable with %d data shards: %w", redundancy, data, err)
+	}
+	return sc, nil
+}
+
+// resolveShards returns the upload shard configuration from the -shards.*,
+// -redundancy, and -no-redundancy flags.
+func resolveShards(parityFlagSet bool) (shardConfig, error) {
+	sc := shards
+	switch {
+	case noRedundancy && (redundancy > 0 || parityFlagSet):
+		return shardConfig{}, errors.New("-no-redundancy cannot be combined with -redundancy or -shards.parity")
+	case redundancy > 0 && parityFlagSet:
+		return shardConfig{}, errors.New("-redundancy cannot be combined with -shards.parity")
+	case noRedundancy:
+		sc.Parity = 0
+	case redundancy > 0:
+		var err error
+		sc, err = shardsForRedundancy(sc.Data, redundancy)
+		if err != nil {
+			return shardConfig{}, err
+		}
+	}
+	return sc, sc.validate()
+}
+
+// A countRange is an inclusive range of counts, parsed from "min:max"
+// or a single count.
+type countRange struct {
+	Min, Max int
+}
+
+func (sr *countRange) String() string {
+	return fmt.Sprintf("%d:%d", sr.Min, sr.Max)
+}
+
+func (sr *countRange) Set(s string) error {
+	lo, hi, ok := strings.Cut(s, ":")
+	if !ok {
+		hi = lo
+	}
+	minCount, err := strconv.Atoi(lo)
+	if err != nil {
+		return fmt.Errorf("invalid minimum %q", lo)
+	}
+	maxCount, err := strconv.Atoi(hi)
+	if err != nil {
+		return fmt.Errorf("invalid maximum %q", hi)
+	} else if maxCount < minCount {
+		return fmt.Errorf("maximum %d is less than minimum %d", maxCount, minCount)
+	}
+	sr.Min, sr.Max = minCount, maxCount
+	return nil
+}
+
+func (sr countRange) sample() int {
+	return sr.Min + frand.Intn(sr.Max-sr.Min+1)
+}
+
+// shardUsage counts the uploads made with a single shard configuration.
+type shardUsage struct {
+	Uploads  int
+	Failures int
+}
+
+// randomShards picks a random shard configuration for each upload and tracks
+// the results of each configuration.
+type randomShards struct {
+	data, parity countRange
+
+	mu    sync.Mutex
+	usage map[shardConfig]*shardUsage
+}
+
+// pick returns a random valid shard configuration.
+func (rs *randomShards) pick() shardConfig {
+	for {
+		sc := shardConfig{Data: rs.data.sample(), Parity: rs.parity.sample()}
+		if sc.validate() == nil {
+			return sc
+		}
+	}
+}
+
+// record adds the result of an upload made with sc.
+func (rs *randomShards) record(sc shardConfig, success bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	u, ok := rs.usage[sc]
+	if !ok {
+		u = new(shardUsage)
+		rs.usage[sc] = u
+	}
+	u.Uploads++
+	if !success {
+		u.Failures++
+	}
+}
+
+// elevatedFailureFactor is how many times the overall failure rate a shard
+// configuration's failure rate must reach to be reported as elevated.
+const elevatedFailureFactor = 2
+
+// minElevatedFailures is the number of failures a shard configuration must
+// have before its failure rate is considered meaningful.
+const minElevatedFailures = 3
+
+// logSummary logs the uploads and failures of each shard configuration that
+// was used, warning about configurations with elevated failure rates.
+func (rs *randomShards) logSummary(log *zap.Logger) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	configs := make([]shardConfig, 0, len(rs.usage))
+	var uploads, failures int
+	for sc, u := range rs.usage {
+		configs = append(configs, sc)
+		uploads += u.Uploads
+		failures += u.Failures
+	}
+	if uploads == 0 {
+		return
+	}
+	slices.SortFunc(configs, func(a, b shardConfig) int {
+		if a.Data != b.Data {
+			return a.Data - b.Data
+		}
+		return a.Parity - b.Parity
+	})
+
+	overall := float64(failures) / float64(uploads)
+	log.Info("random shard summary", zap.Int("configs", len(configs)), zap.Int("uploads", uploads), zap.Int("failures", failures), zap.Float64("failureRate", overall))
+	for _, sc := range configs {
+		u := rs.usage[sc]
+		rate := float64(u.Failures) / float64(u.Uploads)
+		fields := []zap.Field{zap.Stringer("redundancy", sc), zap.Int("uploads", u.Uploads), zap.Int("failures", u.Failures), zap.Float64("failureRate", rate)}
+		if u.Failures >= minElevatedFailures && rate >= overall*elevatedFailureFactor {
+			log.Warn("elevated failure rate for shard configuration", fields...)
+		} else {
+			log.Info("shard configuration summary", fields...)
+		}
+	}
+}
+
+// newRandomShards returns a randomShards picking data and parity counts from
+// the given ranges. An error is returned if no valid configuration is within
+// the ranges.
+func newRandomShards(data, parity countRange) (*randomShards, error) {
+	if err := (shardConfig{Data: data.Min, Parity: parity.Min}).validate(); err != nil {
+		return nil, fmt.Errorf("smallest configuration in range is invalid: %w", err)
+	}
+	return &randomShards{
+		data:   data,
+		parity: parity,
+		usage:  make(map[shardConfig]*shardUsage),
+	}, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// Reasons junkd stops uploading.
+const (
+	causeSignal           = "signal"
+	causeSourceExhausted  = "source exhausted"
+	causeAutotuneComplete = "autotune complete"
+	causeWorkersStopped   = "all workers stopped"
+	causeFatal            = "fatal error"
+	causeFailFast         = "upload failed"
+	causeLimitReached     = "limit reached"
+)
+
+var shutdown struct {
+	mu    sync.Mutex
+	cause string
+}
+
+// setShutdownCause records why junkd is stopping. Only the first cause is
+// kept, since later conditions are usually a consequence of it.
+func setShutdownCause(cause string) {
+	shutdown.mu.Lock()
+	defer shutdown.mu.Unlock()
+	if shutdown.cause == "" {
+		shutdown.cause = cause
+	}
+}
+
+// shutdownCause returns the recorded shutdown cause. If none was recorded the
+// workers exited on their own.
+func shutdownCause() string {
+	shutdown.mu.Lock()
+	defer shutdown.mu.Unlock()
+	if shutdown.cause == "" {
+		return causeWorkersStopped
+	}
+	return shutdown.cause
+}
+
+// shutdownStarted reports whether a shutdown cause has been recorded.
+func shutdownStarted() bool {
+	shutdown.mu.Lock()
+	defer shutdown.mu.Unlock()
+	return shutdown.cause != ""
+}
+
+// Values of -shutdown.mode.
+const (
+	// shutdownDrain finishes in-flight uploads and the rest of each
+	// thread's current batch.
+	shutdownDrain = "drain"
+	// shutdownDrainDropQueued finishes in-flight uploads but drops uploads
+	// that have not started yet.
+	shutdownDrainDropQueued = "drain-inflight-drop-queued"
+	// shutdownCancel cancels in-flight uploads immediately.
+	shutdownCancel = "cancel"
+)
+
+// drainStats counts what happened to uploads once shutdown began.
+type drainStats struct {
+	// inflight uploads started before shutdown began
+	inflightFinished atomic.Int64
+	inflightCanceled atomic.Int64
+	// queued uploads had not started when shutdown began
+	queuedFinished atomic.Int64
+	queuedDropped  atomic.Int64
+	// failed counts uploads that failed for reasons other than shutdown
+	failed atomic.Int64
+}
+
+// record counts an upload that ended after shutdown began. startedBefore
+// is whether the upload started before shutdown began.
+func (ds *drainStats) record(ctx context.Context, startedBefore bool, err error) {
+	switch {
+	case !shutdownStarted():
+	case err != nil && ctx.Err() != nil && startedBefore:
+		ds.inflightCanceled.Add(1)
+	case err != nil:
+		ds.failed.Add(1)
+	case startedBefore:
+		ds.inflightFinished.Add(1)
+	default:
+		ds.queuedFinished.Add(1)
+	}
+}
+
+func (ds *drainStats) log(log *zap.Logger) {
+	log.Info("shutdown uploads",
+		zap.String("mode", shutdownMode),
+		zap.Int64("inflightFinished", ds.inflightFinished.Load()),
+		zap.Int64("inflightCanceled", ds.inflightCanceled.Load()),
+		zap.Int64("queuedFinished", ds.queuedFinished.Load()),
+		zap.Int64("queuedDropped", ds.queuedDropped.Load()),
+		zap.Int64("failed", ds.failed.Load()))
+}
+
+// logShutdown logs why junkd is exiting along with the final counters.
+func logShutdown(log *zap.Logger, stats *uploadStats) {
+	warmupStats, measureStats := stats.phases()
+	log.Info("shutting down",
+		zap.String("cause", shutdownCause()),
+		zap.Int("uploads", warmupStats.Uploads+measureStats.Uploads),
+		zap.Int64("bytes", warmupStats.Bytes+measureStats.Bytes),
+		zap.Int64("redundantBytes", warmupStats.RedundantBytes+measureStats.RedundantBytes),
+		zap.Duration("elapsed", stats.elapsed()))
+}
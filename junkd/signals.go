@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// A signalHandler implements two-stage shutdown. The first signal stops
+// starting new uploads and lets in-flight uploads finish. The second signal
+// cancels in-flight uploads. Before the worker pool is running, or with
+// -shutdown.mode=cancel, the first signal cancels immediately.
+type signalHandler struct {
+	log    *zap.Logger
+	cancel context.CancelFunc
+	pool   atomic.Pointer[workerPool]
+}
+
+func (sh *signalHandler) run(sigs <-chan os.Signal) {
+	sig := <-sigs
+	setShutdownCause(causeSignal)
+	if pool := sh.pool.Load(); pool != nil && shutdownMode != shutdownCancel {
+		sh.log.Info("received signal, finishing in-flight uploads, signal again to cancel them", zap.Stringer("signal", sig), zap.String("mode", shutdownMode))
+		pool.Drain()
+	} else {
+		sh.log.Info("received signal, shutting down", zap.Stringer("signal", sig), zap.String("mode", shutdownMode))
+		sh.cancel()
+		return
+	}
+
+	sig = <-sigs
+	sh.log.Warn("received second signal, canceling in-flight uploads", zap.Stringer("signal", sig))
+	sh.cancel()
+}
+
+// SetPool sets the pool drained by the first signal.
+func (sh *signalHandler) SetPool(pool *workerPool) {
+	sh.pool.Store(pool)
+}
+
+// newSignalHandler starts handling SIGINT and SIGTERM. cancel is called to
+// cancel in-flight work.
+func newSignalHandler(log *zap.Logger, cancel context.CancelFunc) *signalHandler {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	sh := &signalHandler{log: log, cancel: cancel}
+	go sh.run(sigs)
+	return sh
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"lukechampine.com/frand"
+)
+
+// A sizeDist is a weighted distribution of object sizes that uploads sample
+// from.
+type sizeDist struct {
+	sizes      []int64
+	cumWeights []float64
+	// floor is the minimum size returned by sample. Smaller sizes are
+	// clamped up to it.
+	floor int64
+
+	mu      sync.Mutex
+	samples int
+	clamped int
+	sum     int64
+	min     int64
+	max     int64
+}
+
+// sample returns a random size from the distribution, clamped to at least
+// the floor.
+func (sd *sizeDist) sample() int64 {
+	total := sd.cumWeights[len(sd.cumWeights)-1]
+	i := sort.SearchFloat64s(sd.cumWeights, frand.Float64()*total)
+	size := sd.sizes[min(i, len(sd.sizes)-1)]
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	if size < sd.floor {
+		size = sd.floor
+		sd.clamped++
+	}
+	if sd.samples == 0 || size < sd.min {
+		sd.min = size
+	}
+	if size > sd.max {
+		sd.max = size
+	}
+	sd.samples++
+	sd.sum += size
+	return size
+}
+
+// sampled returns the number of sizes sampled, how many were clamped to the
+// floor, and their min, mean, and max.
+func (sd *sizeDist) sampled() (n, clamped int, minSize, mean, maxSize int64) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	if sd.samples == 0 {
+		return 0, 0, 0, 0, 0
+	}
+	return sd.samples, sd.clamped, sd.min, sd.sum / int64(sd.samples), sd.max
+}
+
+// loadSizeDist reads a size distribution from a file containing one size in
+// bytes per line, optionally followed by a weight. Sizes without a weight
+// have a weight of 1.
+func loadSizeDist(path string) (*sizeDist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open size distribution: %w", err)
+	}
+	defer f.Close()
+
+	sd := new(sizeDist)
+	var total float64
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		} else if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a size and optional weight", line)
+		}
+
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("line %d: size must be a positive integer, got %q", line, fields[0])
+		}
+		weight := 1.0
+		if len(fields) == 2 {
+			weight, err = strconv.ParseFloat(fields[1], 64)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("line %d: weight must be a positive number, got %q", line, fields[1])
+			}
+		}
+		total += weight
+		sd.sizes = append(sd.sizes, size)
+		sd.cumWeights = append(sd.cumWeights, total)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read size distribution: %w", err)
+	} else if len(sd.sizes) == 0 {
+		return nil, fmt.Errorf("size distribution %q is empty", path)
+	}
+	return sd, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"lukechampine.com/frand"
+)
+
+// A chunk is the data for a single upload.
+type chunk struct {
+	// Open returns a reader for the chunk's data. Each call starts from the
+	// beginning so a failed upload can be retried.
+	Open func() io.Reader
+	// Size is the number of bytes the reader will produce.
+	Size int64
+	// Index is the position of the chunk within the source.
+	Index uint64
+}
+
+// A dataSource provides the data for each upload.
+type dataSource interface {
+	// Next returns a chunk of up to n bytes. io.EOF is returned when the
+	// source is exhausted.
+	Next(n int64) (chunk, error)
+}
+
+type randomSource struct {
+	r     io.Reader
+	index atomic.Uint64
+}
+
+func (s *randomSource) Next(n int64) (chunk, error) {
+	// random content doesn't need to match between attempts
+	open := func() io.Reader { return io.LimitReader(s.r, n) }
+	return chunk{Open: open, Size: n, Index: s.index.Add(1) - 1}, nil
+}
+
+// A seededSource deterministically generates the content of each chunk from
+// a seed and the chunk's index. The content of chunk i is always the first
+// Size bytes of seededContent(seed, i), so any process knowing the seed and a
+// chunk's recorded index can regenerate it.
+type seededSource struct {
+	seed  string
+	index atomic.Uint64
+}
+
+func (s *seededSource) Next(n int64) (chunk, error) {
+	i := s.index.Add(1) - 1
+	open := func() io.Reader { return io.LimitReader(seededContent(s.seed, i), n) }
+	return chunk{Open: open, Size: n, Index: i}, nil
+}
+
+// seededContent returns the deterministic content stream for the chunk at
+// index i. The stream is a ChaCha12 keystream keyed with
+// SHA-256(seed || little-endian uint64 i).
+func seededContent(seed string, i uint64) io.Reader {
+	h := sha256.New()
+	h.Write([]byte(seed))
+	binary.Write(h, binary.LittleEndian, i)
+	return frand.NewCustom(h.Sum(nil), 1024, 12)
+}
+
+func newRandomSource(name string) (dataSource, error) {
+	switch name {
+	case "frand":
+		return &randomSource{r: frand.Reader}, nil
+	case "crypto":
+		return &randomSource{r: rand.Reader}, nil
+	default:
+		return nil, fmt.Errorf("unknown random source %q", name)
+	}
+}
+
+// A fileSource splits a file into sequential chunks. The final chunk may be
+// shorter than requested.
+type fileSource struct {
+	mu    sync.Mutex
+	r     io.Reader
+	done  bool
+	index uint64
+}
+
+// Close closes the underlying file.
+func (s *fileSource) Close() error {
+	if c, ok := s.r.(io.Closer); ok && s.r != os.Stdin {
+		return c.Close()
+	}
+	return nil
+}
+
+func (s *fileSource) Next(n int64) (chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return chunk{}, io.EOF
+	}
+
+	buf := make([]byte, n)
+	read, err := io.ReadFull(s.r, buf)
+	if errors.Is(err, io.EOF) {
+		s.done = true
+		return chunk{}, io.EOF
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		s.done = true
+	} else if err != nil {
+		return chunk{}, fmt.Errorf("failed to read source: %w", err)
+	}
+	buf = buf[:read]
+	c := chunk{Open: func() io.Reader { return bytes.NewReader(buf) }, Size: int64(read), Index: s.index}
+	s.index++
+	return c, nil
+}
+
+// openFileSource opens the file at path as a data source. A path of "-"
+// reads from stdin.
+func openFileSource(path string) (*fileSource, error) {
+	if path == "-" {
+		return &fileSource{r: os.Stdin}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source: %w", err)
+	}
+	return &fileSource{r: f}, nil
+}
+
+// A pregenSource cycles through a ring of pre-generated files so no data is
+// generated while uploading. Chunks larger than a single file span
+// consecutive files in the ring.
+type pregenSource struct {
+	dir   string
+	files []*os.File
+	size  int64
+	index atomic.Uint64
+}
+
+func (s *pregenSource) Next(n int64) (chunk, error) {
+	i := s.index.Add(1) - 1
+	open := func() io.Reader {
+		var readers []io.Reader
+		for j, rem := uint64(0), n; rem > 0; j++ {
+			f := s.files[(i+j)%uint64(len(s.files))]
+			l := min(rem, s.size)
+			readers = append(readers, io.NewSectionReader(f, 0, l))
+			rem -= l
+		}
+		return io.MultiReader(readers...)
+	}
+	return chunk{Open: open, Size: n, Index: i}, nil
+}
+
+// Close closes and removes the pre-generated files.
+func (s *pregenSource) Close() error {
+	for _, f := range s.files {
+		f.Close()
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// newPregenSource writes count files of size bytes from src to a temporary
+// directory.
+func newPregenSource(src dataSource, count int, size int64) (_ *pregenSource, err error) {
+	dir, err := os.MkdirTemp("", "junkd-pregen-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	s := &pregenSource{dir: dir, size: size}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%d.dat", i)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file: %w", err)
+		}
+		s.files = append(s.files, f)
+
+		c, err := src.Next(size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate data: %w", err)
+		} else if _, err := io.Copy(f, c.Open()); err != nil {
+			return nil, fmt.Errorf("failed to write file: %w", err)
+		} else if err := f.Sync(); err != nil {
+			return nil, fmt.Errorf("failed to sync file: %w", err)
+		}
+	}
+	return s, nil
+}
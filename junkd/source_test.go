@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lukechampine.com/frand"
+)
+
+func TestFileSource(t *testing.T) {
+	sc := shardConfig{Data: 2, Parity: 4}
+	slabSize := sc.slabSize()
+
+	tests := []struct {
+		size   int64
+		chunks []int64
+		slabs  int64
+	}{
+		{size: 1, chunks: []int64{1}, slabs: 1},
+		{size: slabSize + 1, chunks: []int64{slabSize, 1}, slabs: 2},
+	}
+	for _, tt := range tests {
+		data := frand.Bytes(int(tt.size))
+		path := filepath.Join(t.TempDir(), "source")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := openFileSource(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fs.Close()
+
+		var read []byte
+		for i, want := range tt.chunks {
+			c, err := fs.Next(slabSize)
+			if err != nil {
+				t.Fatalf("size %d: chunk %d: %v", tt.size, i, err)
+			} else if c.Size != want {
+				t.Fatalf("size %d: chunk %d: expected %d bytes, got %d", tt.size, i, want, c.Size)
+			} else if c.Index != uint64(i) {
+				t.Fatalf("size %d: chunk %d: expected index %d, got %d", tt.size, i, i, c.Index)
+			}
+			buf, err := io.ReadAll(c.Open())
+			if err != nil {
+				t.Fatal(err)
+			} else if int64(len(buf)) != c.Size {
+				t.Fatalf("size %d: chunk %d: read %d bytes, expected %d", tt.size, i, len(buf), c.Size)
+			}
+			read = append(read, buf...)
+		}
+		if _, err := fs.Next(slabSize); !errors.Is(err, io.EOF) {
+			t.Fatalf("size %d: expected io.EOF after the last chunk, got %v", tt.size, err)
+		} else if string(read) != string(data) {
+			t.Fatalf("size %d: chunks do not match the file", tt.size)
+		}
+
+		if n := expectedSlabs(tt.size, sc); n != tt.slabs {
+			t.Fatalf("size %d: expected %d slabs, got %d", tt.size, tt.slabs, n)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // register the sqlite driver
+)
+
+const (
+	// dbQueueSize is the number of upload rows buffered for the writer
+	// before recording an upload blocks.
+	dbQueueSize = 1024
+	// dbBatchSize is the maximum number of rows inserted in a single
+	// transaction.
+	dbBatchSize = 256
+)
+
+const dbSchema = `CREATE TABLE IF NOT EXISTS uploads (
+	id INTEGER PRIMARY KEY,
+	run_id TEXT NOT NULL,
+	time TEXT NOT NULL,
+	thread TEXT NOT NULL,
+	object_index INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	duration_ms REAL NOT NULL,
+	slab_ids TEXT,
+	error TEXT
+);
+CREATE INDEX IF NOT EXISTS uploads_run_id ON uploads (run_id);`
+
+// A dbUpload is a single upload attempt recorded in the database.
+type dbUpload struct {
+	Time     time.Time
+	Thread   string
+	Index    uint64
+	Size     int64
+	Duration time.Duration
+	SlabIDs  []string
+	Error    string
+}
+
+// A sqliteRecorder records every upload attempt in a SQLite database. Rows
+// are inserted by a single writer goroutine so uploads never contend on the
+// database.
+type sqliteRecorder struct {
+	db  *sql.DB
+	log *zap.Logger
+
+	uploads chan dbUpload
+	wg      sync.WaitGroup
+
+	// mu guards uploads against sends after Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (sr *sqliteRecorder) run() {
+	defer sr.wg.Done()
+
+	batch := make([]dbUpload, 0, dbBatchSize)
+	for u := range sr.uploads {
+		batch = append(batch[:0], u)
+		// insert everything that is already queued in the same transaction
+	fill:
+		for len(batch) < dbBatchSize {
+			select {
+			case u, ok := <-sr.uploads:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, u)
+			default:
+				break fill
+			}
+		}
+		if err := sr.insert(batch); err != nil {
+			sr.log.Warn("failed to record uploads", zap.Int("uploads", len(batch)), zap.Error(err))
+		}
+	}
+}
+
+func (sr *sqliteRecorder) insert(batch []dbUpload) error {
+	tx, err := sr.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO uploads (run_id, time, thread, object_index, size, duration_ms, slab_ids, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, u := range batch {
+		var slabIDs, uploadErr any
+		if len(u.SlabIDs) > 0 {
+			buf, _ := json.Marshal(u.SlabIDs)
+			slabIDs = string(buf)
+		}
+		if u.Error != "" {
+			uploadErr = u.Error
+		}
+		_, err := stmt.Exec(runID, u.Time.UTC().Format(time.RFC3339Nano), u.Thread, int64(u.Index), u.Size, float64(u.Duration)/float64(time.Millisecond), slabIDs, uploadErr)
+		if err != nil {
+			return fmt.Errorf("failed to insert upload: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Record queues an upload to be written to the database.
+func (sr *sqliteRecorder) Record(u dbUpload) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	if sr.closed {
+		return
+	}
+	sr.uploads <- u
+}
+
+// Close writes any queued uploads and closes the database.
+func (sr *sqliteRecorder) Close() error {
+	sr.mu.Lock()
+	if sr.closed {
+		sr.mu.Unlock()
+		return nil
+	}
+	sr.closed = true
+	close(sr.uploads)
+	sr.mu.Unlock()
+
+	sr.wg.Wait()
+	return sr.db.Close()
+}
+
+// openSQLiteRecorder opens the SQLite database at path, creating it and the
+// uploads table if they don't exist.
+func openSQLiteRecorder(log *zap.Logger, path string) (*sqliteRecorder, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// the writer goroutine is the only user of the database
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(dbSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	sr := &sqliteRecorder{
+		db:      db,
+		log:     log,
+		uploads: make(chan dbUpload, dbQueueSize),
+	}
+	sr.wg.Add(1)
+	go sr.run()
+	return sr, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSQLiteRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploads.db")
+	sr, err := openSQLiteRecorder(zap.NewNop(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr.Record(dbUpload{Time: time.Now(), Thread: "0", Index: 1, Size: 100, Duration: time.Second, SlabIDs: []string{"a", "b"}})
+	sr.Record(dbUpload{Time: time.Now(), Thread: "1", Index: 2, Size: 200, Duration: time.Second, Error: "failed"})
+	if err := sr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	} else if journalMode != "wal" {
+		t.Fatalf("expected wal journal mode, got %q", journalMode)
+	}
+
+	rows, err := db.Query(`SELECT object_index, size, slab_ids, error FROM uploads ORDER BY object_index`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var index, size int64
+		var slabIDs, uploadErr sql.NullString
+		if err := rows.Scan(&index, &size, &slabIDs, &uploadErr); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, slabIDs.String+uploadErr.String)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	} else if len(got) != 2 || got[0] != `["a","b"]` || got[1] != "failed" {
+		t.Fatalf("unexpected rows %q", got)
+	}
+}
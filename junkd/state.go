@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// statsState is the persisted state used to continue counting across
+// restarts.
+type statsState struct {
+	// RunID is the ID of the run that last saved the state.
+	RunID    string     `json:"runID"`
+	Lifetime phaseStats `json:"lifetime"`
+	// Window is the rolling window of recent uploads, restored so the
+	// current speed is continuous across restarts.
+	Window  []windowSample `json:"window,omitempty"`
+	SavedAt time.Time      `json:"savedAt"`
+}
+
+// newStatsState returns the current state of stats.
+func newStatsState(stats *uploadStats) statsState {
+	return statsState{
+		RunID:    runID,
+		Lifetime: stats.lifetimeStats(),
+		Window:   stats.windowSamples(),
+		SavedAt:  time.Now(),
+	}
+}
+
+func loadStatsState(path string) (statsState, error) {
+	var state statsState
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return statsState{}, err
+	} else if err := json.Unmarshal(buf, &state); err != nil {
+		return statsState{}, fmt.Errorf("failed to decode stats state: %w", err)
+	}
+	return state, nil
+}
+
+// saveStatsState atomically writes the state to path.
+func saveStatsState(path string, state statsState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode stats state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return fmt.Errorf("failed to write stats state: %w", err)
+	} else if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename stats state: %w", err)
+	}
+	return nil
+}
+
+// restoreStatsState loads the lifetime totals from path into stats. A missing
+// or corrupt state file starts the totals fresh. The rolling window is only
+// restored if the state was saved within maxAge, since older samples no
+// longer reflect the current speed.
+func restoreStatsState(log *zap.Logger, path string, maxAge time.Duration, stats *uploadStats) {
+	state, err := loadStatsState(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Info("no stats state found, starting fresh", zap.String("path", path))
+		return
+	} else if err != nil {
+		log.Warn("failed to load stats state, starting fresh", zap.String("path", path), zap.Error(err))
+		return
+	}
+	stats.addLifetime(state.Lifetime)
+	if age := time.Since(state.SavedAt); len(state.Window) > 0 && age > maxAge {
+		log.Info("discarding stale window from stats state", zap.Duration("age", age), zap.Duration("maxAge", maxAge))
+	} else if len(state.Window) > 0 {
+		// shift the samples forward by the time since they were saved so the window's time
+		// span, and the upload rate computed from it, do not include it
+		for i := range state.Window {
+			state.Window[i].Time = state.Window[i].Time.Add(age)
+		}
+		stats.restoreWindow(state.Window)
+		log.Debug("restored rolling window", zap.Int("samples", len(state.Window)))
+	}
+	log.Info("restored stats state", zap.String("path", path), zap.Int("uploads", state.Lifetime.Uploads), zap.Int64("bytes", state.Lifetime.Bytes), zap.String("previousRunID", state.RunID), zap.Time("savedAt", state.SavedAt))
+}
+
+// persistStatsState periodically saves the lifetime totals to path until the
+// context is canceled.
+func persistStatsState(ctx context.Context, log *zap.Logger, path string, interval time.Duration, stats *uploadStats) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// failed writes are retried on the next interval
+			outputs.Report(log, "stats.state", saveStatsState(path, newStatsState(stats)))
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// maxWindowSamples is the number of recent upload durations kept to
+	// compute rolling statistics.
+	maxWindowSamples = 1000
+	// minPercentileSamples is the minimum number of samples required before
+	// window percentiles are considered meaningful.
+	minPercentileSamples = 20
+)
+
+// phaseStats aggregates the uploads completed during one phase of a run.
+type phaseStats struct {
+	Uploads        int           `json:"uploads"`
+	Bytes          int64         `json:"bytes"`
+	RedundantBytes int64         `json:"redundantBytes"`
+	Duration       time.Duration `json:"duration"`
+}
+
+func (ps *phaseStats) add(size, redundant int64, d time.Duration) {
+	ps.Uploads++
+	ps.Bytes += size
+	ps.RedundantBytes += redundant
+	ps.Duration += d
+}
+
+func (ps phaseStats) meanDuration() time.Duration {
+	if ps.Uploads == 0 {
+		return 0
+	}
+	return ps.Duration / time.Duration(ps.Uploads)
+}
+
+// speed returns the average per-upload speed of the phase.
+func (ps phaseStats) speed() string {
+	return formatBpsString(ps.RedundantBytes, ps.Duration)
+}
+
+// A windowSample is a single upload in the rolling window.
+type windowSample struct {
+	Time           time.Time     `json:"time"`
+	Duration       time.Duration `json:"duration"`
+	RedundantBytes int64         `json:"redundantBytes"`
+}
+
+// uploadStats tracks the uploads completed by all threads.
+type uploadStats struct {
+	warmupEnd time.Time
+
+	mu       sync.Mutex
+	lifetime phaseStats
+	warmup   phaseStats
+	measure  phaseStats
+	window   []windowSample
+
+	batches       int
+	batchDuration time.Duration
+
+	// failures counts failed uploads by error type
+	failures map[string]int
+
+	timeline throughputTimeline
+	fit      sizeLatencyFit
+	// heatmap, if set, accumulates upload durations over time.
+	heatmap *latencyHeatmap
+}
+
+// record adds a completed upload and returns the number of uploads completed
+// during this run. Uploads that complete before the end of the warmup period
+// are tracked separately and excluded from the rolling window.
+func (s *uploadStats) record(size, redundant int64, d time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps := &s.measure
+	if time.Now().Before(s.warmupEnd) {
+		ps = &s.warmup
+	} else {
+		s.window = append(s.window, windowSample{Time: time.Now(), Duration: d, RedundantBytes: redundant})
+		if len(s.window) > maxWindowSamples {
+			s.window = s.window[len(s.window)-maxWindowSamples:]
+		}
+	}
+	ps.add(size, redundant, d)
+	s.lifetime.add(size, redundant, d)
+	s.timeline.add(time.Now(), size, redundant)
+	s.fit.add(size, d)
+	if s.heatmap != nil {
+		s.heatmap.add(time.Now(), d)
+	}
+	return s.warmup.Uploads + s.measure.Uploads
+}
+
+// recordFailure adds a failed upload with the given error type.
+func (s *uploadStats) recordFailure(errType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[errType]++
+}
+
+// outcomes returns the number of successful uploads during this run and a
+// copy of the failure counts by error type.
+func (s *uploadStats) outcomes() (successes int, failures map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.warmup.Uploads + s.measure.Uploads, maps.Clone(s.failures)
+}
+
+// successRate returns the fraction of uploads that succeeded given the
+// number of successes and failures by error type.
+func successRate(successes int, failures map[string]int) (float64, bool) {
+	total := successes
+	for _, n := range failures {
+		total += n
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(successes) / float64(total), true
+}
+
+// recordBatch adds a completed batch of uploads.
+func (s *uploadStats) recordBatch(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	s.batchDuration += d
+}
+
+// batchMean returns the number of completed batches and their mean
+// duration.
+func (s *uploadStats) batchMean() (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.batches == 0 {
+		return 0, 0
+	}
+	return s.batches, s.batchDuration / time.Duration(s.batches)
+}
+
+// windowSpeed returns the redundant bytes and total duration of the most
+// recent uploads, from which their average per-upload speed is computed.
+func (s *uploadStats) windowSpeed() (redundant int64, d time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.window) == 0 {
+		return 0, 0, false
+	}
+	for _, ws := range s.window {
+		redundant += ws.RedundantBytes
+		d += ws.Duration
+	}
+	return redundant, d, true
+}
+
+// windowPercentile returns the p-th percentile (0-100) of the most recent
+// upload durations.
+func (s *uploadStats) windowPercentile(p float64) (time.Duration, bool) {
+	s.mu.Lock()
+	if len(s.window) < minPercentileSamples {
+		s.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, len(s.window))
+	for i, ws := range s.window {
+		sorted[i] = ws.Duration
+	}
+	s.mu.Unlock()
+
+	slices.Sort(sorted)
+	return percentile(sorted, p), true
+}
+
+// windowSamples returns a copy of the rolling window.
+func (s *uploadStats) windowSamples() []windowSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.window)
+}
+
+// restoreWindow prepends samples from a previous run to the rolling window.
+func (s *uploadStats) restoreWindow(samples []windowSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = append(slices.Clone(samples), s.window...)
+	if len(s.window) > maxWindowSamples {
+		s.window = s.window[len(s.window)-maxWindowSamples:]
+	}
+}
+
+// windowOpsPerSecond returns the rate at which the most recent uploads
+// completed.
+func (s *uploadStats) windowOpsPerSecond() (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.window) < 2 {
+		return 0, false
+	}
+	span := s.window[len(s.window)-1].Time.Sub(s.window[0].Time)
+	if span <= 0 {
+		return 0, false
+	}
+	return float64(len(s.window)-1) / span.Seconds(), true
+}
+
+// totalRedundantBytes returns the number of redundant bytes uploaded during
+// this run.
+func (s *uploadStats) totalRedundantBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.warmup.RedundantBytes + s.measure.RedundantBytes
+}
+
+// lifetimeStats returns the totals across all runs, including those restored
+// from a previous run.
+func (s *uploadStats) lifetimeStats() phaseStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lifetime
+}
+
+// addLifetime adds the totals of previous runs to the lifetime stats.
+func (s *uploadStats) addLifetime(ps phaseStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lifetime.Uploads += ps.Uploads
+	s.lifetime.Bytes += ps.Bytes
+	s.lifetime.RedundantBytes += ps.RedundantBytes
+	s.lifetime.Duration += ps.Duration
+}
+
+// sizeLatency returns the linear fit of upload duration against size.
+func (s *uploadStats) sizeLatency() (perMB, overhead time.Duration, r float64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fit.result()
+}
+
+// timelineBuckets returns a copy of the throughput timeline.
+func (s *uploadStats) timelineBuckets() []timelineBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.timeline.buckets)
+}
+
+// writeHeatmapCSV writes the latency heatmap to path.
+func (s *uploadStats) writeHeatmapCSV(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heatmap.writeCSV(path)
+}
+
+// elapsed returns the time since the start of the run.
+func (s *uploadStats) elapsed() time.Duration {
+	return time.Since(s.timeline.start)
+}
+
+// phases returns the warmup and measurement phase stats.
+func (s *uploadStats) phases() (warmup, measure phaseStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.warmup, s.measure
+}
+
+func newUploadStats(warmup, timelineWidth time.Duration) *uploadStats {
+	now := time.Now()
+	return &uploadStats{
+		warmupEnd: now.Add(warmup),
+		failures:  make(map[string]int),
+		timeline: throughputTimeline{
+			start: now,
+			width: timelineWidth,
+		},
+	}
+}
+
+// percentile returns the p-th percentile (0-100) of a sorted slice of
+// durations using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// statsdPrefix is prepended to the name of every metric.
+	statsdPrefix = "junkd."
+	// statsdMaxPacket is the maximum size of a single UDP packet. Metrics
+	// are batched into packets up to this size, which fits in a typical
+	// Ethernet MTU.
+	statsdMaxPacket = 1432
+	// statsdFlushInterval is the maximum time a metric is buffered before it
+	// is sent.
+	statsdFlushInterval = time.Second
+	// statsdQueueSize is the number of metrics buffered for sending before
+	// new metrics are dropped.
+	statsdQueueSize = 4096
+	// statsdGaugeInterval is how often the thread and throughput gauges are
+	// sent.
+	statsdGaugeInterval = 10 * time.Second
+)
+
+// A statsdClient sends metrics to a statsd server over UDP without blocking
+// the caller. Metrics are batched into packets and dropped if the queue is
+// full.
+type statsdClient struct {
+	conn net.Conn
+	log  *zap.Logger
+	// tags is appended to every metric line
+	tags string
+
+	metrics chan string
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+
+	// mu guards metrics against sends after Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (sc *statsdClient) run() {
+	defer sc.wg.Done()
+
+	t := time.NewTicker(statsdFlushInterval)
+	defer t.Stop()
+
+	var buf bytes.Buffer
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, err := sc.conn.Write(buf.Bytes()); err != nil {
+			sc.log.Debug("failed to send metrics", zap.Error(err))
+		}
+		buf.Reset()
+	}
+	for {
+		select {
+		case m, ok := <-sc.metrics:
+			if !ok {
+				flush()
+				return
+			}
+			if buf.Len() > 0 && buf.Len()+1+len(m) > statsdMaxPacket {
+				flush()
+			}
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(m)
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+func (sc *statsdClient) send(name, value, kind string) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if sc.closed {
+		return
+	}
+	select {
+	case sc.metrics <- statsdPrefix + name + ":" + value + "|" + kind + sc.tags:
+	default:
+		sc.dropped.Add(1)
+	}
+}
+
+// Count adds n to a counter.
+func (sc *statsdClient) Count(name string, n int64) {
+	sc.send(name, fmt.Sprint(n), "c")
+}
+
+// Timing sends a timer in milliseconds.
+func (sc *statsdClient) Timing(name string, d time.Duration) {
+	sc.send(name, fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond)), "ms")
+}
+
+// Gauge sets a gauge.
+func (sc *statsdClient) Gauge(name string, v float64) {
+	sc.send(name, fmt.Sprintf("%g", v), "g")
+}
+
+// Close sends any buffered metrics and closes the connection. Metrics sent
+// after Close are discarded.
+func (sc *statsdClient) Close() error {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return nil
+	}
+	sc.closed = true
+	close(sc.metrics)
+	sc.mu.Unlock()
+
+	sc.wg.Wait()
+	if n := sc.dropped.Load(); n > 0 {
+		sc.log.Warn("dropped statsd metrics, queue was full", zap.Int64("dropped", n))
+	}
+	return sc.conn.Close()
+}
+
+// sendGauges periodically sends the number of active threads and the upload
+// throughput until ctx is canceled.
+func (sc *statsdClient) sendGauges(ctx context.Context, pool *workerPool, stats *uploadStats) {
+	t := time.NewTicker(statsdGaugeInterval)
+	defer t.Stop()
+
+	last, lastTime := stats.totalRedundantBytes(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			total := stats.totalRedundantBytes()
+			sc.Gauge("threads", float64(pool.Size()))
+			sc.Gauge("throughput", float64(total-last)/now.Sub(lastTime).Seconds())
+			if ops, ok := stats.windowOpsPerSecond(); ok {
+				sc.Gauge("ops", ops)
+			}
+			last, lastTime = total, now
+		}
+	}
+}
+
+func newStatsdClient(log *zap.Logger, addr string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd: %w", err)
+	}
+	sc := &statsdClient{
+		conn:    conn,
+		log:     log,
+		tags:    labels.statsdTags(),
+		metrics: make(chan string, statsdQueueSize),
+	}
+	sc.wg.Add(1)
+	go sc.run()
+	return sc, nil
+}
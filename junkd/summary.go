@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// A runSummary describes the configuration and results of a single run.
+type runSummary struct {
+	RunID        string
+	Start        time.Time
+	End          time.Time
+	Mode         string
+	Threads      int
+	DataShards   int
+	ParityShards int
+	Labels       labelSet
+
+	Measure phaseStats
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+
+	// ReusedConns and NewConns count the indexer requests that reused an
+	// idle connection or needed a new one.
+	ReusedConns int64
+	NewConns    int64
+}
+
+func newRunSummary(stats *uploadStats) runSummary {
+	_, measure := stats.phases()
+	rs := runSummary{
+		RunID:        runID,
+		Start:        stats.timeline.start,
+		End:          time.Now(),
+		Mode:         mode,
+		Threads:      threads,
+		DataShards:   shards.Data,
+		ParityShards: shards.Parity,
+		Labels:       labels,
+		Measure:      measure,
+	}
+	rs.P50, _ = stats.windowPercentile(50)
+	rs.P90, _ = stats.windowPercentile(90)
+	rs.P99, _ = stats.windowPercentile(99)
+	return rs
+}
+
+var summaryCSVHeader = []string{
+	"runID", "start", "end", "mode", "threads", "dataShards", "parityShards",
+	"uploads", "bytes", "redundantBytes", "meanDurationMs",
+	"p50Ms", "p90Ms", "p99Ms", "averageSpeed", "labels",
+}
+
+func (rs runSummary) csvRecord() []string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatInt(d.Milliseconds(), 10)
+	}
+	return []string{
+		rs.RunID,
+		rs.Start.Format(time.RFC3339),
+		rs.End.Format(time.RFC3339),
+		rs.Mode,
+		strconv.Itoa(rs.Threads),
+		strconv.Itoa(rs.DataShards),
+		strconv.Itoa(rs.ParityShards),
+		strconv.Itoa(rs.Measure.Uploads),
+		strconv.FormatInt(rs.Measure.Bytes, 10),
+		strconv.FormatInt(rs.Measure.RedundantBytes, 10),
+		ms(rs.Measure.meanDuration()),
+		ms(rs.P50),
+		ms(rs.P90),
+		ms(rs.P99),
+		rs.Measure.speed(),
+		rs.Labels.String(),
+	}
+}
+
+// appendSummaryCSV appends the summary as a single row to the CSV file at
+// path, writing a header first if the file is new. Appending to a file
+// written with a different set of columns fails rather than mixing layouts
+// in one file.
+func appendSummaryCSV(path string, rs runSummary) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open summary file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat summary file: %w", err)
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(summaryCSVHeader)
+	} else {
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = -1
+		header, err := r.Read()
+		if err != nil {
+			return fmt.Errorf("failed to read summary header: %w", err)
+		} else if !slices.Equal(header, summaryCSVHeader) {
+			return fmt.Errorf("summary file %q has different columns than this version of junkd writes, use a new file", path)
+		}
+	}
+	w.Write(rs.csvRecord())
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+	return f.Close()
+}
+
+// printSummaryTable writes a human-readable recap of the run to w,
+// independent of the log format.
+func printSummaryTable(w io.Writer, rs runSummary, successes int, failures map[string]int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(name string, value any) {
+		fmt.Fprintf(tw, "%s\t%v\n", name, value)
+	}
+	row("RUN", rs.RunID)
+	row("MODE", rs.Mode)
+	if len(rs.Labels) > 0 {
+		row("LABELS", rs.Labels)
+	}
+	row("ELAPSED", rs.End.Sub(rs.Start).Round(time.Second))
+	row("REDUNDANCY", shardConfig{Data: rs.DataShards, Parity: rs.ParityShards})
+	if entropy < 1 {
+		row("ENTROPY", entropy)
+	}
+	if len(affinityCPUs) > 0 {
+		row("AFFINITY", len(affinityCPUs))
+	}
+	row("UPLOADS", rs.Measure.Uploads)
+	row("BYTES", rs.Measure.Bytes)
+	row("REDUNDANT BYTES", rs.Measure.RedundantBytes)
+	row("AVERAGE SPEED", rs.Measure.speed())
+	row("MEAN DURATION", rs.Measure.meanDuration())
+	row("P50", rs.P50)
+	row("P90", rs.P90)
+	row("P99", rs.P99)
+	if rs.ReusedConns+rs.NewConns > 0 {
+		row("INDEXER API CONN REUSE", fmt.Sprintf("%.2f%%", reusePercent(rs.ReusedConns, rs.NewConns)))
+	}
+	if rate, ok := successRate(successes, failures); ok {
+		row("SUCCESS RATE", fmt.Sprintf("%.2f%%", rate*100))
+	}
+	for _, errType := range slices.Sorted(maps.Keys(failures)) {
+		row("FAILURES ("+errType+")", failures[errType])
+	}
+	tw.Flush()
+}
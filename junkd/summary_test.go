@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppendSummaryCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.csv")
+	for range 2 {
+		if err := appendSummaryCSV(path, runSummary{RunID: "run"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d records", len(records))
+	}
+}
+
+func TestAppendSummaryCSVLayoutMismatch(t *testing.T) {
+	tests := map[string][]string{
+		"without runID":  summaryCSVHeader[1:],
+		"without labels": summaryCSVHeader[:len(summaryCSVHeader)-1],
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			oldHeader := strings.Join(header, ",") + "\n"
+			path := filepath.Join(t.TempDir(), "summary.csv")
+			if err := os.WriteFile(path, []byte(oldHeader), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := appendSummaryCSV(path, runSummary{RunID: "run"}); err == nil {
+				t.Fatal("expected appending to a file with a different layout to fail")
+			}
+			if buf, err := os.ReadFile(path); err != nil {
+				t.Fatal(err)
+			} else if string(buf) != oldHeader {
+				t.Fatal("file was modified")
+			}
+		})
+	}
+}
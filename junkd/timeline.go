@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A timelineBucket is the uploads completed within one bucket of the run.
+type timelineBucket struct {
+	Uploads        int
+	Bytes          int64
+	RedundantBytes int64
+}
+
+// A throughputTimeline counts the bytes uploaded in fixed-width time buckets
+// since the start of the run. It is not safe for concurrent use.
+type throughputTimeline struct {
+	start   time.Time
+	width   time.Duration
+	buckets []timelineBucket
+}
+
+func (tl *throughputTimeline) add(t time.Time, size, redundant int64) {
+	i := int(t.Sub(tl.start) / tl.width)
+	if i < 0 {
+		i = 0
+	}
+	for len(tl.buckets) <= i {
+		tl.buckets = append(tl.buckets, timelineBucket{})
+	}
+	tl.buckets[i].Uploads++
+	tl.buckets[i].Bytes += size
+	tl.buckets[i].RedundantBytes += redundant
+}
+
+func logTimeline(log *zap.Logger, width time.Duration, buckets []timelineBucket) {
+	for i, b := range buckets {
+		log.Info("throughput timeline", zap.Duration("offset", time.Duration(i)*width), zap.Int("uploads", b.Uploads), zap.Int64("bytes", b.Bytes), zap.String("throughput", formatBpsString(b.RedundantBytes, width)))
+	}
+}
+
+func writeTimelineCSV(path string, start time.Time, width time.Duration, buckets []timelineBucket) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create timeline file: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"start", "offsetSeconds", "uploads", "bytes", "redundantBytes"})
+	for i, b := range buckets {
+		offset := time.Duration(i) * width
+		w.Write([]string{
+			start.Add(offset).Format(time.RFC3339),
+			strconv.FormatFloat(offset.Seconds(), 'f', -1, 64),
+			strconv.Itoa(b.Uploads),
+			strconv.FormatInt(b.Bytes, 10),
+			strconv.FormatInt(b.RedundantBytes, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write timeline: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.sia.tech/indexd/sdk"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// minUploadDuration is the smallest upload duration that is recorded.
+const minUploadDuration = time.Millisecond
+
+// errUnexpectedSlabs is returned when an upload does not produce the expected
+// number of slabs for its size.
+var errUnexpectedSlabs = errors.New("unexpected number of slabs")
+
+// errUploadTimeout is returned when an upload exceeds its deadline.
+var errUploadTimeout = errors.New("upload timed out")
+
+// errLatencyBudget is returned when an upload is canceled for running longer
+// than the latency budget.
+var errLatencyBudget = errors.New("upload exceeded latency budget")
+
+// An uploader uploads objects to the indexer and records the results.
+type uploader struct {
+	apps    []*appClient
+	next    atomic.Uint64
+	source  dataSource
+	ids     *idWriter
+	records *recordsWriter
+	stats   *uploadStats
+
+	// limiter, if set, limits the rate at which uploads are started across
+	// all workers.
+	limiter *rate.Limiter
+	// retryBudget, if set, limits the rate of retries across all workers.
+	retryBudget *rate.Limiter
+	// breaker, if set, pauses uploads on sustained failures.
+	breaker *circuitBreaker
+	// sizes, if set, is sampled for the size of each upload. Otherwise each
+	// upload is a single slab.
+	sizes *sizeDist
+	// randomShards, if set, picks the shard configuration of each upload.
+	// Otherwise the global configuration is used.
+	randomShards *randomShards
+	// compare, if set, alternates the shard configuration of each upload
+	// between two configurations.
+	compare *shardComparison
+	// dedup, if set, is the data source and records repeated and random
+	// uploads separately.
+	dedup *dedupProbe
+	// metrics, if set, receives a metric for each upload.
+	metrics *statsdClient
+	// deadLetters, if set, records uploads that failed after exhausting
+	// their retries.
+	deadLetters *deadLetterWriter
+	// events, if set, receives an event for every upload attempt.
+	events *eventWriter
+	// db, if set, records every upload attempt.
+	db *sqliteRecorder
+	// replay, if set, provides the size and start time of each upload.
+	replay *replaySchedule
+	// limits, if set, stops the run after a number of objects or bytes.
+	limits *uploadLimits
+	// failFast, if set, is called to cancel the run on the first failed
+	// upload.
+	failFast context.CancelFunc
+
+	// drain counts what happened to uploads during shutdown.
+	drain drainStats
+
+	// cancellations is the number of uploads canceled for exceeding the
+	// latency budget.
+	cancellations atomic.Int64
+
+	// now, if set, replaces time.Now for timing uploads.
+	now func() time.Time
+}
+
+// A pendingUpload is an object waiting to be uploaded. It is kept across
+// failed attempts so the same object is retried.
+type pendingUpload struct {
+	chunk
+	shards   shardConfig
+	attempts int
+	// scheduled is when a replayed upload should start
+	scheduled time.Time
+}
+
+// nextUpload returns the next object to upload from the data source.
+func (u *uploader) nextUpload() (*pendingUpload, error) {
+	sc := shards
+	if u.randomShards != nil {
+		sc = u.randomShards.pick()
+	} else if u.compare != nil {
+		sc = u.compare.pick()
+	}
+	want := sc.slabSize()
+	var scheduled time.Time
+	if u.sizes != nil {
+		want = u.sizes.sample()
+	} else if u.replay != nil {
+		var err error
+		if want, scheduled, err = u.replay.nextUpload(); err != nil {
+			return nil, err
+		}
+	}
+	if u.limits != nil {
+		var ok bool
+		if want, ok = u.limits.reserve(want); !ok {
+			return nil, errLimitReached
+		}
+	}
+	c, err := u.source.Next(want)
+	if err != nil {
+		if u.limits != nil {
+			u.limits.release(1, want)
+		}
+		return nil, err
+	} else if u.limits != nil && c.Size < want {
+		u.limits.release(0, want-c.Size)
+	}
+	return &pendingUpload{chunk: c, shards: sc, scheduled: scheduled}, nil
+}
+
+// uploadObject uploads a single object and returns the number of redundant
+// bytes uploaded.
+func (u *uploader) uploadObject(ctx context.Context, log *zap.Logger, p *pendingUpload) (int64, error) {
+	c, sc := p.chunk, p.shards
+	if u.randomShards != nil || u.compare != nil {
+		log = log.With(zap.Stringer("redundancy", sc))
+	}
+	size, redundant := c.Size, sc.redundantSize(c.Size)
+
+	var h hash.Hash
+	r := c.Open()
+	if outputHash {
+		h = sha256.New()
+		r = io.TeeReader(r, h)
+	}
+
+	a := pickApp(u.apps, &u.next)
+	if len(u.apps) > 1 {
+		log = log.With(zap.Int("app", a.index))
+	}
+
+	uploadCtx := ctx
+	if timeout := uploadDeadline(size); timeout > 0 {
+		var cancel context.CancelFunc
+		uploadCtx, cancel = context.WithTimeoutCause(uploadCtx, timeout, errUploadTimeout)
+		defer cancel()
+	}
+	if cancelFactor > 0 {
+		if p99, ok := u.stats.windowPercentile(99); ok {
+			var cancel context.CancelFunc
+			uploadCtx, cancel = context.WithTimeoutCause(uploadCtx, time.Duration(float64(p99)*cancelFactor), errLatencyBudget)
+			defer cancel()
+		}
+	}
+
+	start := u.timeNow()
+	startedBefore := !shutdownStarted()
+	obj, err := a.client.Load().Upload(uploadCtx, injectFault(uploadCtx, r), sdk.WithRedundancy(sc.Data, sc.Parity))
+	elapsed := u.elapsed(log, start)
+	u.drain.record(ctx, startedBefore, err)
+	if err != nil && ctx.Err() == nil && errors.Is(context.Cause(uploadCtx), errLatencyBudget) {
+		u.cancellations.Add(1)
+		if u.metrics != nil {
+			u.metrics.Count("upload.canceled", 1)
+		}
+		err = fmt.Errorf("%w after %s", errLatencyBudget, elapsed)
+		u.recordAttempt(log, c, elapsed, nil, err)
+		return 0, err
+	} else if err != nil {
+		u.recordAttempt(log, c, elapsed, nil, err)
+		a.failures.Add(1)
+		if u.randomShards != nil {
+			u.randomShards.record(sc, false)
+		}
+		if u.compare != nil {
+			u.compare.recordFailure(sc)
+		}
+		if u.dedup != nil && ctx.Err() == nil {
+			u.dedup.recordFailure(c)
+		}
+		if u.metrics != nil {
+			u.metrics.Count("upload.failure", 1)
+		}
+		if ctx.Err() == nil && errors.Is(context.Cause(uploadCtx), errUploadTimeout) {
+			return 0, fmt.Errorf("%w after %s: %w", errUploadTimeout, elapsed, err)
+		}
+		return 0, err
+	} else if expected := expectedSlabs(size, sc); int64(len(obj.Slabs)) != expected {
+		err = fmt.Errorf("%w: expected %d slabs, got %d", errUnexpectedSlabs, expected, len(obj.Slabs))
+		u.recordAttempt(log, c, elapsed, nil, err)
+		return 0, err
+	}
+	a.uploads.Add(1)
+	a.redundantBytes.Add(redundant)
+	if u.randomShards != nil {
+		u.randomShards.record(sc, true)
+	}
+	if u.compare != nil {
+		u.compare.record(sc, size, redundant, elapsed)
+	}
+	if u.metrics != nil {
+		u.metrics.Count("upload.success", 1)
+		u.metrics.Count("upload.bytes", size)
+		u.metrics.Count("upload.redundant_bytes", redundant)
+		u.metrics.Timing("upload.duration", elapsed)
+	}
+	if stragglerFactor > 0 {
+		if p99, ok := u.stats.windowPercentile(99); ok && float64(elapsed) > float64(p99)*stragglerFactor {
+			log.Warn("straggler upload detected", zap.Stringer("SlabID", obj.Slabs[0].ID), zap.Duration("duration", elapsed), zap.Duration("p99", p99), zap.Float64("factor", stragglerFactor))
+		}
+	}
+	if n := u.stats.record(size, redundant, elapsed); notifier != nil && webhookEvery > 0 && n%webhookEvery == 0 {
+		notifier.Notify(newWebhookEvent("milestone", u.stats))
+	}
+
+	slabIDs := make([]string, len(obj.Slabs))
+	for i, slab := range obj.Slabs {
+		slabIDs[i] = slab.ID.String()
+	}
+	if u.dedup != nil {
+		u.dedup.record(c, slabIDs, redundant, elapsed)
+	}
+	if u.ids != nil {
+		outputs.Report(log, "output", u.ids.WriteUpload(slabIDs, size, c.Index, h))
+	}
+
+	if u.records != nil {
+		outputs.Report(log, "records.csv", u.records.Write(uploadRecord{
+			Time:          time.Now(),
+			Thread:        log.Name(),
+			Size:          size,
+			RedundantSize: redundant,
+			Duration:      elapsed,
+			SlabID:        obj.Slabs[0].ID.String(),
+		}))
+	}
+
+	u.recordAttempt(log, c, elapsed, slabIDs, nil)
+	log.Info("upload completed", zap.Stringer("SlabID", obj.Slabs[0].ID), zap.Int("slabs", len(obj.Slabs)), zap.Int64("size", size), zap.Int64("redundantSize", redundant), zap.Duration("duration", elapsed), zap.String("speed", formatBpsString(redundant, elapsed)))
+	return redundant, nil
+}
+
+// recordAttempt writes an upload attempt to the -events.stdout stream and
+// the -db.sqlite database, if set. err is nil for successful uploads.
+func (u *uploader) recordAttempt(log *zap.Logger, c chunk, elapsed time.Duration, slabIDs []string, err error) {
+	if u.events == nil && u.db == nil {
+		return
+	}
+	now := time.Now()
+	var errStr string
+	if err != nil {
+		errStr = err.Error()
+	}
+	if u.events != nil {
+		ev := uploadEvent{
+			Time:     now,
+			Thread:   log.Name(),
+			Index:    c.Index,
+			Size:     c.Size,
+			Duration: elapsed,
+			Error:    errStr,
+		}
+		if len(slabIDs) > 0 {
+			ev.SlabID = slabIDs[0]
+		}
+		outputs.Report(log, "events.stdout", u.events.Write(ev))
+	}
+	if u.db != nil {
+		u.db.Record(dbUpload{
+			Time:     now,
+			Thread:   log.Name(),
+			Index:    c.Index,
+			Size:     c.Size,
+			Duration: elapsed,
+			SlabIDs:  slabIDs,
+			Error:    errStr,
+		})
+	}
+}
+
+// abort cancels the run with -fail-fast, including in-flight uploads on
+// other threads.
+func (u *uploader) abort() {
+	if u.failFast == nil {
+		return
+	}
+	setShutdownCause(causeFailFast)
+	u.failFast()
+}
+
+// recordDeadLetter records an upload that failed after exhausting its
+// retries.
+func (u *uploader) recordDeadLetter(log *zap.Logger, p *pendingUpload, err error) {
+	if u.deadLetters == nil {
+		return
+	}
+	outputs.Report(log, "deadletter.file", u.deadLetters.Write(deadLetter{
+		Time:     time.Now(),
+		RunID:    runID,
+		Thread:   log.Name(),
+		Index:    p.Index,
+		Size:     p.Size,
+		Attempts: p.attempts,
+		Error:    err.Error(),
+	}))
+}
+
+// timeNow returns the current time from u.now, or time.Now if it is unset.
+func (u *uploader) timeNow() time.Time {
+	if u.now != nil {
+		return u.now()
+	}
+	return time.Now()
+}
+
+// elapsed returns the duration of an upload that started at start, clamped
+// by clampDuration so successful and failed attempts are never recorded
+// with a negative or zero duration.
+func (u *uploader) elapsed(log *zap.Logger, start time.Time) time.Duration {
+	measured := u.timeNow().Sub(start)
+	d, clamped := clampDuration(measured)
+	if clamped {
+		log.Warn("upload duration below minimum, the system clock may have jumped", zap.Duration("duration", measured), zap.Duration("clamped", d))
+	}
+	return d
+}
+
+// clampDuration returns the measured upload duration d, raised to
+// minUploadDuration if it is shorter. time.Since uses the monotonic clock,
+// so this should only happen if the clock misbehaves; clamping keeps a single
+// glitch from corrupting the stats.
+func clampDuration(d time.Duration) (time.Duration, bool) {
+	if d < minUploadDuration {
+		return minUploadDuration, true
+	}
+	return d, false
+}
+
+// expectedSlabs returns the number of slabs an object of size bytes is split
+// into, including a final partial slab.
+func expectedSlabs(size int64, sc shardConfig) int64 {
+	return (size + sc.slabSize() - 1) / sc.slabSize()
+}
+
+// uploadErrorType classifies a failed upload for the failure counters.
+func uploadErrorType(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errUploadTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, errUnexpectedSlabs):
+		return "unexpected"
+	case errors.As(err, &netErr), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// uploadDeadline returns the timeout for an upload of size bytes. If
+// -upload.timeout-per-mb is set the timeout scales with the size on top of
+// the -upload.timeout base, otherwise the flat -upload.timeout is used. Zero
+// means no timeout.
+func uploadDeadline(size int64) time.Duration {
+	if uploadTimeoutPerMB <= 0 {
+		return uploadTimeout
+	}
+	return uploadTimeout + time.Duration(float64(uploadTimeoutPerMB)*float64(size)/(1<<20))
+}
+
+// worker uploads objects until stop is canceled, the data source is
+// exhausted, or an unrecoverable error occurs.
+func (u *uploader) worker(ctx, stop context.Context, log *zap.Logger) {
+	log.Debug("starting upload thread")
+	defer log.Debug("upload thread stopped")
+
+	if len(affinityCPUs) > 0 {
+		// only the worker's own thread is pinned, goroutines started by the
+		// SDK to encode and upload sectors run wherever the scheduler puts
+		// them
+		runtime.LockOSThread()
+		if restore, err := pinThread(affinityCPUs); err != nil {
+			log.Warn("failed to pin upload thread", zap.Error(err))
+			runtime.UnlockOSThread()
+		} else {
+			defer func() {
+				// if the affinity can't be restored the thread stays locked
+				// so the runtime discards it when the worker exits
+				if err := restore(); err != nil {
+					log.Warn("failed to unpin upload thread", zap.Error(err))
+					return
+				}
+				runtime.UnlockOSThread()
+			}()
+		}
+	}
+
+	// each thread has its own limiter so it behaves like an independent
+	// rate-limited client
+	var limiter *rate.Limiter
+	var uploads int
+	// pending is the object being retried, if any
+	var pending *pendingUpload
+	if threadRate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(threadRate), 1)
+		start := time.Now()
+		defer func() {
+			log.Info("upload thread rate", zap.Int("uploads", uploads), zap.Float64("targetRate", threadRate), zap.Float64("achievedRate", float64(uploads)/time.Since(start).Seconds()))
+		}()
+	}
+
+loop:
+	for stop.Err() == nil {
+		batchStart := time.Now()
+		var batchBytes int64
+		for i := 0; i < batchSize; i++ {
+			// once a batch has started, a stopped thread finishes it
+			// unless -shutdown.mode drops queued uploads
+			wait := stop
+			if i > 0 && stop.Err() != nil {
+				if shutdownMode == shutdownDrainDropQueued {
+					if shutdownStarted() {
+						u.drain.queuedDropped.Add(int64(batchSize - i))
+					}
+					return
+				}
+				wait = ctx
+			}
+
+			if u.limiter != nil {
+				if err := u.limiter.Wait(wait); err != nil {
+					return
+				}
+			}
+
+			if limiter != nil {
+				if err := limiter.Wait(wait); err != nil {
+					return
+				}
+			}
+
+			if u.breaker != nil {
+				if err := u.breaker.Wait(wait); err != nil {
+					return
+				}
+			}
+
+			start := time.Now()
+			var n int64
+			var err error
+			if pending == nil {
+				pending, err = u.nextUpload()
+				if err == nil && !pending.scheduled.IsZero() {
+					if d := time.Until(pending.scheduled); d > 0 && !waitFor(stop, d) {
+						if u.breaker != nil {
+							u.breaker.Release()
+						}
+						return
+					}
+					u.replay.recordStart(pending.scheduled, time.Now())
+				}
+			}
+			if err == nil {
+				pending.attempts++
+				n, err = u.uploadObject(ctx, log, pending)
+			}
+			if u.breaker != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, errLimitReached) || errors.Is(err, errLatencyBudget) {
+					// no upload outcome to record, but a half-open probe
+					// must still be released
+					u.breaker.Release()
+				} else {
+					u.breaker.Record(err == nil)
+				}
+			}
+			if errors.Is(err, errLimitReached) {
+				log.Debug("upload limit reached")
+				setShutdownCause(causeLimitReached)
+				return
+			} else if errors.Is(err, io.EOF) {
+				log.Debug("data source exhausted")
+				setShutdownCause(causeSourceExhausted)
+				return
+			} else if errors.Is(err, errUnexpectedSlabs) {
+				u.stats.recordFailure(uploadErrorType(err))
+				log.Error("upload returned an unexpected object", zap.Error(err))
+				u.abort()
+				return
+			} else if errors.Is(err, errLatencyBudget) {
+				// the upload was slow, not failed, so start a new one
+				// immediately
+				log.Debug("canceled slow upload", zap.Error(err))
+				if u.limits != nil {
+					u.limits.release(1, pending.Size)
+				}
+				pending = nil
+				continue loop
+			} else if err != nil {
+				if ctx.Err() == nil {
+					// uploads interrupted by shutdown did not fail
+					u.stats.recordFailure(uploadErrorType(err))
+				}
+				if u.failFast != nil {
+					if ctx.Err() == nil {
+						log.Error("upload failed, stopping run", zap.Error(err), zap.Duration("duration", time.Since(start)))
+						u.abort()
+					}
+					return
+				}
+				fields := []zap.Field{zap.Error(err), zap.Duration("duration", time.Since(start))}
+				if pending != nil {
+					fields = append(fields, zap.Int("attempt", pending.attempts))
+					if pending.attempts > uploadRetries && ctx.Err() == nil {
+						u.recordDeadLetter(log, pending, err)
+						if u.limits != nil {
+							// let another object take the abandoned one's place
+							u.limits.release(1, pending.Size)
+						}
+						pending = nil
+					}
+				}
+				log.Error("failed to upload slab, timing out for 5 minutes", fields...)
+				if ok := waitFor(stop, 5*time.Minute); !ok {
+					if pending != nil && shutdownStarted() {
+						// the retry is dropped
+						u.drain.queuedDropped.Add(1)
+					}
+					return
+				}
+				if u.retryBudget != nil && !u.retryBudget.Allow() {
+					log.Warn("retry budget exhausted, waiting to retry")
+					if err := u.retryBudget.Wait(stop); err != nil {
+						return
+					}
+				}
+				continue loop
+			}
+			batchBytes += n
+			uploads++
+			pending = nil
+		}
+
+		if batchSize > 1 {
+			d := time.Since(batchStart)
+			u.stats.recordBatch(d)
+			log.Info("batch completed", zap.Int("objects", batchSize), zap.Duration("duration", d), zap.String("speed", formatBpsString(batchBytes, d)))
+		}
+	}
+}
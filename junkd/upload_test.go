@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestClampDuration(t *testing.T) {
+	tests := []struct {
+		d       time.Duration
+		want    time.Duration
+		clamped bool
+	}{
+		{0, minUploadDuration, true},
+		{-5 * time.Second, minUploadDuration, true}, // clock stepped backward
+		{500 * time.Microsecond, minUploadDuration, true},
+		{minUploadDuration, minUploadDuration, false},
+		{2 * time.Second, 2 * time.Second, false},
+	}
+	for _, tt := range tests {
+		got, clamped := clampDuration(tt.d)
+		if got != tt.want || clamped != tt.clamped {
+			t.Errorf("clampDuration(%v) = %v, %v; want %v, %v", tt.d, got, clamped, tt.want, tt.clamped)
+		}
+	}
+}
+
+func TestElapsedClockStepsBackward(t *testing.T) {
+	// the clock steps back a minute while the upload is in flight
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events bytes.Buffer
+	u := &uploader{
+		stats:  newUploadStats(0, time.Minute),
+		events: newEventWriter(&events),
+		now: func() time.Time {
+			t := now
+			now = now.Add(-time.Minute)
+			return t
+		},
+	}
+
+	start := u.timeNow()
+	elapsed := u.elapsed(zap.NewNop(), start)
+	if elapsed != minUploadDuration {
+		t.Fatalf("expected elapsed to be clamped to %v, got %v", minUploadDuration, elapsed)
+	}
+	u.stats.record(100, 300, elapsed)
+	u.recordAttempt(zap.NewNop(), chunk{Size: 100}, elapsed, nil, errors.New("failed"))
+
+	lifetime := u.stats.lifetime
+	if lifetime.Duration <= 0 || lifetime.RedundantBytes != 300 {
+		t.Fatalf("unexpected lifetime stats %+v", lifetime)
+	}
+	for _, s := range u.stats.windowSamples() {
+		if s.Duration <= 0 {
+			t.Fatalf("negative window sample %v", s.Duration)
+		}
+	}
+	var ev uploadEvent
+	if err := json.Unmarshal(events.Bytes(), &ev); err != nil {
+		t.Fatal(err)
+	} else if ev.Duration <= 0 {
+		t.Fatalf("failed attempt recorded with duration %v", ev.Duration)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// webhookTimeout is the maximum time spent delivering a single event.
+	webhookTimeout = 5 * time.Second
+	// webhookQueueSize is the number of events buffered for delivery before
+	// new events are dropped.
+	webhookQueueSize = 64
+)
+
+// A webhookEvent is posted to the webhook URL as JSON on run milestones.
+type webhookEvent struct {
+	RunID      string    `json:"runID"`
+	Event      string    `json:"event"`
+	Labels     labelSet  `json:"labels,omitempty"`
+	Time       time.Time `json:"time"`
+	Uploads    int       `json:"uploads"`
+	Bytes      int64     `json:"bytes"`
+	Throughput string    `json:"throughput"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// notifier, if set, receives run events. It is package-level so fatal
+// failures can be reported before exiting.
+var notifier *webhookNotifier
+
+// A webhookNotifier delivers events to a URL without blocking the caller.
+type webhookNotifier struct {
+	url    string
+	log    *zap.Logger
+	client *http.Client
+
+	events chan webhookEvent
+	wg     sync.WaitGroup
+
+	// mu guards events against sends after Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (wn *webhookNotifier) post(ev webhookEvent) error {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.url, bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (wn *webhookNotifier) run() {
+	defer wn.wg.Done()
+	for ev := range wn.events {
+		if err := wn.post(ev); err != nil {
+			wn.log.Warn("failed to deliver webhook event", zap.String("event", ev.Event), zap.Error(err))
+		}
+	}
+}
+
+// Notify queues an event for delivery. The event is dropped if the queue is
+// full or the notifier is closed.
+func (wn *webhookNotifier) Notify(ev webhookEvent) {
+	wn.mu.RLock()
+	defer wn.mu.RUnlock()
+	if wn.closed {
+		return
+	}
+	select {
+	case wn.events <- ev:
+	default:
+		wn.log.Warn("webhook queue full, dropping event", zap.String("event", ev.Event))
+	}
+}
+
+// Close delivers any queued events, then delivers the final event
+// synchronously.
+func (wn *webhookNotifier) Close(final webhookEvent) {
+	wn.mu.Lock()
+	if wn.closed {
+		wn.mu.Unlock()
+		return
+	}
+	wn.closed = true
+	close(wn.events)
+	wn.mu.Unlock()
+
+	wn.wg.Wait()
+	if err := wn.post(final); err != nil {
+		wn.log.Warn("failed to deliver webhook event", zap.String("event", final.Event), zap.Error(err))
+	}
+}
+
+func newWebhookNotifier(log *zap.Logger, url string) *webhookNotifier {
+	wn := &webhookNotifier{
+		url: url,
+		log: log,
+		// use a separate transport so events are not included in the
+		// indexer request timings
+		client: &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}},
+		events: make(chan webhookEvent, webhookQueueSize),
+	}
+	wn.wg.Add(1)
+	go wn.run()
+	return wn
+}
+
+// newWebhookEvent returns an event populated with the current progress of
+// the run.
+func newWebhookEvent(event string, stats *uploadStats) webhookEvent {
+	ev := webhookEvent{
+		RunID:  runID,
+		Event:  event,
+		Labels: labels,
+		Time:   time.Now(),
+	}
+	if stats != nil {
+		warmup, measure := stats.phases()
+		ev.Uploads = warmup.Uploads + measure.Uploads
+		ev.Bytes = warmup.Bytes + measure.Bytes
+		ev.Throughput = formatBpsString(warmup.RedundantBytes+measure.RedundantBytes, stats.elapsed())
+	}
+	return ev
+}
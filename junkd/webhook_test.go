@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestWebhookNotifyAfterClose(t *testing.T) {
+	var received atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	wn := newWebhookNotifier(zap.NewNop(), srv.URL)
+	wn.Notify(webhookEvent{Event: "start"})
+	wn.Close(webhookEvent{Event: "complete"})
+	// neither may panic or deliver anything
+	wn.Notify(webhookEvent{Event: "milestone"})
+	wn.Close(webhookEvent{Event: "complete"})
+
+	if n := received.Load(); n != 2 {
+		t.Fatalf("expected 2 events delivered, got %d", n)
+	}
+}